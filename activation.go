@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// socketUnit 是一个已激活的.socket单元。
+type socketUnit struct {
+	// service 是有连接到来时启动的服务
+	service string
+	// listeners 是ListenStream=对应的监听套接字
+	listeners []net.Listener
+	// files 是传给服务的监听套接字文件，按ListenStream=的顺序从fd 3开始
+	files []*os.File
+}
+
+// mapSocket 跟踪已激活的socket单元，受lock保护，与mapCommand中的服务进程分开管理
+var mapSocket = map[string]*socketUnit{}
+
+// findUnitFile 按unitDirs的顺序查找单元文件（如x.service、x.socket、x.timer），返回第一个找到的路径。
+func findUnitFile(file string) string {
+	for _, dir := range unitDirs() {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// enabledUnits 返回enablePath中所有已启用的指定后缀单元的名称（不含后缀）。
+func enabledUnits(suffix string) []string {
+	matches, _ := filepath.Glob(filepath.Join(enablePath, "*"+suffix))
+	var names []string
+	for _, match := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(match), suffix))
+	}
+	return names
+}
+
+// StartSocket 激活socket单元：打开ListenStream=中的所有监听套接字，
+// 并在第一个连接到来时启动对应的服务，通过LISTEN_FDS协议将套接字传给它。
+// 只支持Accept=no，即由一个服务实例处理所有连接。
+func StartSocket(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if mapSocket[name] != nil {
+		return nil
+	}
+	path := findUnitFile(name + ".socket")
+	if path == "" {
+		return errors.New("no socket found")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	opts, err := parseSystemdService(string(content))
+	if err != nil {
+		return err
+	}
+	if accept, _ := getOptions(opts, "Socket", "Accept"); accept == "yes" || accept == "true" {
+		return fmt.Errorf("Accept=%s is not supported for %s.socket", accept, name)
+	}
+	addresses := getAllOptions(opts, "Socket", "ListenStream")
+	if len(addresses) == 0 {
+		return fmt.Errorf("%s.socket has no ListenStream=", name)
+	}
+
+	socket := &socketUnit{service: name}
+	if val, _ := getOptions(opts, "Socket", "Service"); val != "" {
+		socket.service = strings.TrimSuffix(val, ".service")
+	}
+	for _, address := range addresses {
+		listener, file, err2 := listenStream(address)
+		if err2 != nil {
+			socket.close()
+			return err2
+		}
+		socket.listeners = append(socket.listeners, listener)
+		socket.files = append(socket.files, file)
+	}
+	mapSocket[name] = socket
+	getState(socket.service).listenFiles = socket.files
+	for _, listener := range socket.listeners {
+		go socket.watch(name, listener)
+	}
+	log.Printf("Listening on %s for %s.service\n", strings.Join(addresses, ", "), socket.service)
+	return nil
+}
+
+// StopSocket 关闭socket单元的监听套接字，已启动的服务不受影响。
+func StopSocket(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	socket := mapSocket[name]
+	if socket == nil {
+		return errors.New("socket is not listening")
+	}
+	socket.close()
+	delete(mapSocket, name)
+	if state := mapState[socket.service]; state != nil {
+		state.listenFiles = nil
+	}
+	return nil
+}
+
+// listenStream 按ListenStream=的值创建监听套接字：以/开头为Unix套接字路径，
+// 纯数字为监听所有地址的TCP端口，其余按"地址:端口"处理。同时返回传给服务的套接字文件。
+func listenStream(address string) (net.Listener, *os.File, error) {
+	network := "tcp"
+	switch {
+	case strings.HasPrefix(address, "/"):
+		network = "unix"
+		_ = os.Remove(address)
+	case isPort(address):
+		address = ":" + address
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	var file *os.File
+	switch l := listener.(type) {
+	case *net.TCPListener:
+		file, err = l.File()
+	case *net.UnixListener:
+		file, err = l.File()
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, nil, err
+	}
+	return listener, file, nil
+}
+
+// isPort 判断字符串是否为合法的端口号。
+func isPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n < 65536
+}
+
+// watch 等待监听套接字上有连接到来，此时若服务未运行则启动它。
+// 服务启动后由它自己接受连接，直到服务退出后才重新开始等待。监听套接字关闭后返回。
+func (s *socketUnit) watch(name string, listener net.Listener) {
+	conn, err := listener.(syscall.Conn).SyscallConn()
+	if err != nil {
+		log.Printf("Failed to watch %s.socket: %v\n", name, err)
+		return
+	}
+	for {
+		// 回调第一次返回false使运行时等待套接字可读，再次被调用时即有连接到来
+		waited := false
+		err = conn.Read(func(uintptr) bool {
+			if waited {
+				return true
+			}
+			waited = true
+			return false
+		})
+		if err != nil {
+			return
+		}
+
+		unlock := lockService(s.service)
+		if mapSocket[name] != s {
+			unlock()
+			return
+		}
+		if !isCommandRunning(s.service, mapCommand[s.service]) {
+			log.Printf("Connection on %s.socket, starting %s.service\n", name, s.service)
+			if err = start(s.service); err != nil {
+				log.Printf("Failed to activate %s.service: %v\n", s.service, err)
+			}
+		}
+		unlock()
+
+		// 服务运行期间由它处理连接，退出后恢复等待
+		for {
+			time.Sleep(time.Second)
+			lock.Lock()
+			running := isCommandRunning(s.service, mapCommand[s.service])
+			current := mapSocket[name] == s
+			lock.Unlock()
+			if !current {
+				return
+			}
+			if !running {
+				break
+			}
+		}
+	}
+}
+
+// close 关闭socket单元的所有监听套接字。
+func (s *socketUnit) close() {
+	for _, listener := range s.listeners {
+		_ = listener.Close()
+	}
+	for _, file := range s.files {
+		_ = file.Close()
+	}
+}
+
+// socketActivated 为通过socket激活的服务设置传递监听套接字所需的文件和环境变量。
+// LISTEN_PID必须等于服务进程自身的PID，只有在fork之后才能确定，
+// 因此命令会经由"systemctl exec-listen"中转，由它设置LISTEN_PID后再exec真正的程序。
+func socketActivated(command *exec.Cmd, files []*os.File) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	command.ExtraFiles = files
+	command.Env = append(command.Env, "LISTEN_FDS="+strconv.Itoa(len(files)))
+	command.Args = append([]string{"systemctl", "exec-listen", command.Path}, command.Args...)
+	command.Path = self
+	return nil
+}
+
+// execListen 是exec-listen子命令的实现：设置LISTEN_PID为当前进程PID后exec目标程序，
+// args为目标程序路径和完整的argv。
+func execListen(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: systemctl exec-listen <path> <argv...>")
+	}
+	env := append(os.Environ(), "LISTEN_PID="+strconv.Itoa(os.Getpid()))
+	return syscall.Exec(args[0], args[1:], env)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// authToken 是守护进程要求客户端提供的令牌，为空表示不启用认证
+var authToken string
+
+// loadToken 读取tokenPath中的共享令牌。文件不存在时返回空字符串，表示不启用认证。
+// 令牌文件必须只有属主可以访问（0600），且令牌不能包含":"或换行。
+func loadToken() (string, error) {
+	info, err := os.Stat(tokenPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("token file %s must not be accessible by group or others (mode %04o, want 0600)", tokenPath, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" || strings.ContainsAny(token, ":\n") {
+		return "", fmt.Errorf("invalid token in %s", tokenPath)
+	}
+	return token, nil
+}
+
+// clientToken 返回客户端发送请求时附带的令牌。令牌文件不存在或无权读取时返回空字符串，
+// 此时若守护进程启用了认证，请求会被拒绝。
+func clientToken() string {
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// authenticate 在启用认证时校验请求的第一个字段"token:"并返回去掉令牌后的消息。
+func authenticate(msg string) (string, error) {
+	if authToken == "" {
+		return msg, nil
+	}
+	token, rest, ok := strings.Cut(msg, ":")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) != 1 {
+		log.Println("Rejected unauthenticated request")
+		return "", errors.New("authentication required")
+	}
+	return rest, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// batchInverse 记录可回滚操作的逆操作，用于原子批量执行失败时回滚
+var batchInverse = map[string]string{
+	"start":   "stop",
+	"stop":    "start",
+	"enable":  "disable",
+	"disable": "enable",
+}
+
+// batchOp 是批量请求中的单个操作。
+type batchOp struct {
+	op      string
+	service string
+	args    []string
+}
+
+// buildBatch 从读取器中逐行读取"operation service [args...]"格式的操作，
+// 并编码为发送给守护进程的批量请求消息。空行和以#开头的行会被忽略。
+func buildBatch(r io.Reader, atomic bool) (string, error) {
+	mode := ""
+	if atomic {
+		mode = "atomic"
+	}
+	lines := []string{"batch:" + mode}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return "", fmt.Errorf("invalid batch line: %q", line)
+		}
+		lines = append(lines, strings.Join(fields, ":"))
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// handleBatch 按顺序执行批量请求中的所有操作，并返回每个操作的结果。
+// 在原子模式下，遇到第一个失败时停止执行，并按相反顺序回滚已完成且确实改变了状态的可逆操作，
+// 例如批量开始前就在运行的服务，其start是空操作，回滚时不会被停止。
+// 任一操作失败时以错误的形式返回全部结果。
+func handleBatch(msg string) (string, error) {
+	lines := strings.Split(msg, "\n")
+	atomic := strings.TrimPrefix(lines[0], "batch:") == "atomic"
+
+	var ops []batchOp
+	for _, line := range lines[1:] {
+		split := strings.Split(line, ":")
+		if len(split) < 2 {
+			return "", fmt.Errorf("invalid batch line: %q", line)
+		}
+		ops = append(ops, batchOp{
+			op:      split[0],
+			service: strings.ReplaceAll(split[1], ".service", ""),
+			args:    split[2:],
+		})
+	}
+
+	var results []string
+	var done []batchOp
+	failed := false
+	for i, op := range ops {
+		before := batchState(op)
+		res, err := dispatch(op.op, op.service, op.args)
+		if err != nil {
+			failed = true
+			results = append(results, fmt.Sprintf("[%d] %s %s: %v", i+1, op.op, op.service, err))
+			if atomic {
+				results = append(results, rollbackBatch(done)...)
+				break
+			}
+			continue
+		}
+		results = append(results, fmt.Sprintf("[%d] %s %s: %s", i+1, op.op, op.service, res))
+		if _, ok := batchInverse[op.op]; ok && batchState(op) != before {
+			done = append(done, op)
+		}
+	}
+	if failed {
+		return "", errors.New(strings.Join(results, "\n"))
+	}
+	return strings.Join(results, "\n"), nil
+}
+
+// batchState 返回可逆操作所改变的状态：start/stop对应单元是否在运行，enable/disable对应单元是否已启用。
+// 比较操作前后的值即可知道操作是否真正改变了状态。
+func batchState(op batchOp) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	switch op.op {
+	case "start", "stop":
+		if name, ok := strings.CutSuffix(op.service, ".socket"); ok {
+			_, ok = mapSocket[name]
+			return ok
+		}
+		if name, ok := strings.CutSuffix(op.service, ".timer"); ok {
+			_, ok = mapTimer[name]
+			return ok
+		}
+		return isCommandRunning(op.service, mapCommand[op.service])
+	case "enable", "disable":
+		if isOtherUnit(op.service) {
+			path := findUnitFile(op.service)
+			return path != "" && unitFileState(op.service, path) == "enabled"
+		}
+		path := find(op.service)
+		return path != "" && unitFileState(op.service+".service", path) == "enabled"
+	}
+	return false
+}
+
+// rollbackBatch 按相反顺序撤销已完成的操作，返回回滚结果。
+func rollbackBatch(done []batchOp) []string {
+	var results []string
+	for i := len(done) - 1; i >= 0; i-- {
+		inverse, ok := batchInverse[done[i].op]
+		if !ok {
+			continue
+		}
+		log.Printf("Rolling back %s %s with %s\n", done[i].op, done[i].service, inverse)
+		if _, err := dispatch(inverse, done[i].service, nil); err != nil {
+			results = append(results, fmt.Sprintf("rollback %s %s: %v", inverse, done[i].service, err))
+		} else {
+			results = append(results, fmt.Sprintf("rollback %s %s: success", inverse, done[i].service))
+		}
+	}
+	return results
+}
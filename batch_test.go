@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildBatch(t *testing.T) {
+	input := "# comment\n\nstart  web.service\nstop db\nkill web SIGHUP\n"
+	got, err := buildBatch(strings.NewReader(input), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "batch:atomic\nstart:web.service\nstop:db\nkill:web:SIGHUP"; got != want {
+		t.Errorf("buildBatch = %q, want %q", got, want)
+	}
+	if _, err := buildBatch(strings.NewReader("start\n"), false); err == nil {
+		t.Error("buildBatch accepted a line without a service")
+	}
+}
+
+// TestBatchRunsInOrder 检查非原子批量按顺序执行每个操作，失败的操作不影响后续操作，
+// 并为每个操作返回一行结果。
+func TestBatchRunsInOrder(t *testing.T) {
+	setupTestPaths(t)
+	for _, name := range []string{"first", "second", "third"} {
+		writeUnit(t, name+".service", "[Service]\nRestart=always\nExecStart=/bin/sleep 60\n")
+	}
+
+	_, err := handleBatch("batch:\nstart:second\nstart:first\nstart:missing.service\nstart:third")
+	if err == nil {
+		t.Fatal("batch with a missing unit succeeded")
+	}
+	results := strings.Split(err.Error(), "\n")
+	prefixes := []string{"[1] start second: ", "[2] start first: ", "[3] start missing: ", "[4] start third: "}
+	if len(results) != len(prefixes) {
+		t.Fatalf("got %d results, want %d:\n%s", len(results), len(prefixes), strings.Join(results, "\n"))
+	}
+	for i, prefix := range prefixes {
+		if !strings.HasPrefix(results[i], prefix) {
+			t.Errorf("result %d = %q, want prefix %q", i+1, results[i], prefix)
+		}
+	}
+	if results[2] == prefixes[2]+"success" {
+		t.Errorf("missing unit reported success: %q", results[2])
+	}
+
+	lock.Lock()
+	second, first, third := mapState["second"].activeEnter, mapState["first"].activeEnter, mapState["third"].activeEnter
+	lock.Unlock()
+	if !second.Before(first) || !first.Before(third) {
+		t.Errorf("services started out of order: second %v, first %v, third %v", second, first, third)
+	}
+}
+
+func TestAtomicBatchKeepsServicesThatWereAlreadyRunning(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "before.service", "[Service]\nExecStart=/bin/sleep 60\n")
+	writeUnit(t, "fresh.service", "[Service]\nExecStart=/bin/sleep 60\n")
+	writeUnit(t, "broken.service", "[Service]\nExecStart=/nonexistent/daemon\n")
+	if err := Start("before"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := handleBatch("batch:atomic\nstart:before\nstart:fresh\nstart:broken")
+	if err == nil {
+		t.Fatal("batch with a broken unit succeeded")
+	}
+	if !isRunning("before") {
+		t.Errorf("rollback stopped a service that was running before the batch:\n%v", err)
+	}
+	if isRunning("fresh") {
+		t.Errorf("rollback did not stop the service started by the batch:\n%v", err)
+	}
+	if strings.Contains(err.Error(), "rollback stop before") {
+		t.Errorf("rollback included the no-op start:\n%v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultBootConcurrency 是开机时同时启动的服务数的默认上限
+const defaultBootConcurrency = 8
+
+// bootConcurrency 返回开机时同时启动的服务数上限，由--boot-concurrency或SYSTEMCTL_BOOT_CONCURRENCY指定。
+func bootConcurrency() int {
+	if bootJobs == "" {
+		return defaultBootConcurrency
+	}
+	n, err := strconv.Atoi(bootJobs)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid boot concurrency %q, using %d\n", bootJobs, defaultBootConcurrency)
+		return defaultBootConcurrency
+	}
+	return n
+}
+
+// startDefaultTarget 按After=/Before=顺序并行启动default.target（默认为multi-user.target）拉起的服务，
+// adopted中已被接管的服务不再启动。守护进程启动和soft-reboot时调用。
+func startDefaultTarget(adopted map[string]bool) {
+	target := bootTarget()
+	log.Printf("Reaching target %s\n", target)
+	order, cyclic := orderServices(targetServices(target))
+	if len(cyclic) > 0 {
+		log.Printf("Ordering cycle detected among services %s, starting them in name order\n", strings.Join(cyclic, ", "))
+	}
+	bootServices(order, cyclic, adopted)
+}
+
+// SoftReboot 按依赖的逆序停止所有服务，清除它们的失败状态和启动历史，然后像守护进程启动时一样
+// 重新启动default.target拉起的服务。与reboot不同，守护进程本身、已激活的socket和timer单元
+// 以及管理器环境都保持不变，适合在不重启容器的情况下重新初始化整组服务。
+func SoftReboot() {
+	Shutdown()
+	lock.Lock()
+	shuttingDown = false
+	for _, state := range mapState {
+		state.startLimitHit, state.failed, state.starts = false, false, nil
+		state.autoRestarts, state.restarts = 0, 0
+		state.crashes, state.crashLoop, state.crashLoopDisabled = nil, "", false
+	}
+	lock.Unlock()
+	log.Println("Soft reboot: starting services again")
+	startDefaultTarget(nil)
+}
+
+// bootServices 以最多bootConcurrency()个并发启动services中的服务。每个服务等到After=/Before=中
+// 排在它之前的服务启动完成（无论成功与否）后才开始启动，互不相关的服务并行启动。
+// 处于循环依赖中的服务忽略彼此之间的顺序。adopted中的服务已被接管，不再启动。
+func bootServices(services []string, cyclic []string, adopted map[string]bool) {
+	before := bootPredecessors(services, cyclic)
+	done := map[string]chan struct{}{}
+	for _, service := range services {
+		done[service] = make(chan struct{})
+	}
+	slots := make(chan struct{}, bootConcurrency())
+	var wg sync.WaitGroup
+	for _, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[service])
+			for _, dep := range before[service] {
+				<-done[dep]
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			bootService(service, adopted)
+		}()
+	}
+	wg.Wait()
+}
+
+// bootPredecessors 返回开机时每个服务需要等待的服务：before[s]是After=/Before=中必须在s之前启动完成的服务。
+// 处于循环依赖中的服务之间的顺序被忽略。
+func bootPredecessors(services []string, cyclic []string) map[string][]string {
+	set := map[string]bool{}
+	for _, service := range services {
+		set[service] = true
+	}
+	inCycle := map[string]bool{}
+	for _, service := range cyclic {
+		inCycle[service] = true
+	}
+	before := map[string][]string{}
+	for first, afters := range orderingEdges(set) {
+		for after := range afters {
+			if inCycle[first] && inCycle[after] {
+				continue
+			}
+			before[after] = append(before[after], first)
+		}
+	}
+	for _, firsts := range before {
+		sort.Strings(firsts)
+	}
+	return before
+}
+
+// bootService 开机时启动单个服务，PIDFile=中记录的进程仍在运行时直接接管。
+func bootService(service string, adopted map[string]bool) {
+	if adopted[service] {
+		return
+	}
+	lock.Lock()
+	running := adoptRunning(service)
+	lock.Unlock()
+	if running {
+		return
+	}
+	if err := Start(service); err != nil && !errors.Is(err, errAlreadyRunning) && !errors.Is(err, errConditionFailed) {
+		log.Printf("Failed to auto-start service %s.service: %v\n", service, err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// bpf(2)的命令、程序类型和挂载类型，syscall包中没有定义
+const (
+	bpfProgLoad   = 5
+	bpfProgAttach = 8
+
+	bpfProgTypeCgroupSKB = 8
+
+	bpfCgroupInetIngress = 0
+	bpfCgroupInetEgress  = 1
+)
+
+// eBPF指令的操作码组成部分
+const (
+	bpfLD    = 0x00
+	bpfLDX   = 0x01
+	bpfJMP   = 0x05
+	bpfALU64 = 0x07
+
+	bpfW  = 0x00
+	bpfB  = 0x10
+	bpfDW = 0x18
+
+	bpfIMM = 0x00
+	bpfMEM = 0x60
+
+	bpfK = 0x00
+	bpfX = 0x08
+
+	bpfADD = 0x00
+	bpfAND = 0x50
+	bpfRSH = 0x70
+	bpfMOV = 0xb0
+
+	bpfJA   = 0x00
+	bpfJEQ  = 0x10
+	bpfJNE  = 0x50
+	bpfCALL = 0x80
+	bpfEXIT = 0x90
+)
+
+// bpfFuncSkbLoadBytes 是辅助函数bpf_skb_load_bytes的编号
+const bpfFuncSkbLoadBytes = 26
+
+// bpfInsn 是一条eBPF指令，内存布局与内核的struct bpf_insn一致。
+type bpfInsn struct {
+	code uint8
+	// regs 的低4位是目标寄存器，高4位是源寄存器
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// bpfProgram 逐条生成eBPF程序，跳转目标用标签表示，在assemble时换算为相对偏移。
+type bpfProgram struct {
+	insns []bpfInsn
+	// labels 记录每个标签所在的指令位置
+	labels map[string]int
+	// jumps 记录每条跳转指令的位置及其目标标签
+	jumps map[int]string
+}
+
+func newBPFProgram() *bpfProgram {
+	return &bpfProgram{labels: map[string]int{}, jumps: map[int]string{}}
+}
+
+func (p *bpfProgram) emit(code uint8, dst, src uint8, off int16, imm int32) {
+	p.insns = append(p.insns, bpfInsn{code: code, regs: src<<4 | dst, off: off, imm: imm})
+}
+
+// label 把标签放在下一条指令的位置。
+func (p *bpfProgram) label(name string) {
+	p.labels[name] = len(p.insns)
+}
+
+// jump 生成比较dst与src寄存器、条件成立时跳转到标签的指令。
+func (p *bpfProgram) jump(op uint8, dst, src uint8, target string) {
+	p.jumps[len(p.insns)] = target
+	p.emit(bpfJMP|op|bpfX, dst, src, 0, 0)
+}
+
+// jumpImm 生成比较dst寄存器与常量imm、条件成立时跳转到标签的指令；op为bpfJA时无条件跳转。
+func (p *bpfProgram) jumpImm(op uint8, dst uint8, imm int32, target string) {
+	p.jumps[len(p.insns)] = target
+	p.emit(bpfJMP|op|bpfK, dst, 0, 0, imm)
+}
+
+// loadImm64 将64位常量装入寄存器，占用两条指令。
+func (p *bpfProgram) loadImm64(dst uint8, val uint64) {
+	p.emit(bpfLD|bpfDW|bpfIMM, dst, 0, 0, int32(uint32(val)))
+	p.emit(0, 0, 0, 0, int32(uint32(val>>32)))
+}
+
+// bpfScratch 是程序在栈上存放从数据包中复制的数据的位置，相对于帧指针r10
+const bpfScratch = -16
+
+// skbLoadBytes 调用bpf_skb_load_bytes把数据包中从offset开始的size（不超过16）字节复制到栈上的bpfScratch处，
+// 失败时（如包比offset+size短）跳转到标签onError。r6中必须保存着程序的上下文，调用会改写r0至r5。
+func (p *bpfProgram) skbLoadBytes(offset, size int32, onError string) {
+	p.emit(bpfALU64|bpfMOV|bpfX, 1, 6, 0, 0)
+	p.emit(bpfALU64|bpfMOV|bpfK, 2, 0, 0, offset)
+	p.emit(bpfALU64|bpfMOV|bpfX, 3, 10, 0, 0)
+	p.emit(bpfALU64|bpfADD|bpfK, 3, 0, 0, bpfScratch)
+	p.emit(bpfALU64|bpfMOV|bpfK, 4, 0, 0, size)
+	p.emit(bpfJMP|bpfCALL, 0, 0, 0, bpfFuncSkbLoadBytes)
+	p.jumpImm(bpfJNE, 0, 0, onError)
+}
+
+// assemble 填写跳转偏移，返回可以交给内核加载的指令序列。
+func (p *bpfProgram) assemble() ([]bpfInsn, error) {
+	for pc, target := range p.jumps {
+		dest, ok := p.labels[target]
+		if !ok {
+			return nil, fmt.Errorf("undefined BPF label %s", target)
+		}
+		p.insns[pc].off = int16(dest - pc - 1)
+	}
+	return p.insns, nil
+}
+
+// bpfProgLoadAttr 是BPF_PROG_LOAD使用的union bpf_attr的前缀部分。
+type bpfProgLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+// bpfProgAttachAttr 是BPF_PROG_ATTACH使用的union bpf_attr的前缀部分。
+type bpfProgAttachAttr struct {
+	targetFd     uint32
+	attachBPFFd  uint32
+	attachType   uint32
+	attachFlags  uint32
+	replaceBPFFd uint32
+}
+
+// bpfSyscall 执行bpf(2)，返回其结果（如程序的文件描述符）。
+func bpfSyscall(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	if sysBPF == 0 {
+		return 0, syscall.ENOSYS
+	}
+	r, _, errno := syscall.Syscall(sysBPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+// loadCgroupSKBProgram 加载一个cgroup_skb类型的程序，attachType是它将被挂载的方向，返回程序的文件描述符。
+func loadCgroupSKBProgram(name string, insns []bpfInsn, attachType uint32) (int, error) {
+	license := []byte("GPL\x00")
+	attr := bpfProgLoadAttr{
+		progType:           bpfProgTypeCgroupSKB,
+		insnCnt:            uint32(len(insns)),
+		insns:              uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		expectedAttachType: attachType,
+	}
+	copy(attr.progName[:len(attr.progName)-1], name)
+	fd, err := bpfSyscall(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	// attr中只保存了地址，指令和许可证在系统调用返回前必须保持可达
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return 0, fmt.Errorf("load BPF program: %w", err)
+	}
+	return fd, nil
+}
+
+// attachCgroupProgram 将程序挂载到cgroup上。不带标志挂载时替换该cgroup上同一方向已有的程序，
+// 服务在cgroup被删除之前重新启动时不会叠加旧的过滤器。
+func attachCgroupProgram(cgroupFd, progFd int, attachType uint32) error {
+	attr := bpfProgAttachAttr{
+		targetFd:    uint32(cgroupFd),
+		attachBPFFd: uint32(progFd),
+		attachType:  attachType,
+	}
+	if _, err := bpfSyscall(bpfProgAttach, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("attach BPF program: %w", err)
+	}
+	return nil
+}
+
+// nativeUint32 和nativeUint64 按本机字节序解释地址字节，与程序从栈上加载地址时得到的寄存器值一致
+func nativeUint32(b []byte) uint64 { return uint64(binary.NativeEndian.Uint32(b)) }
+func nativeUint64(b []byte) uint64 { return binary.NativeEndian.Uint64(b) }
@@ -0,0 +1,4 @@
+package main
+
+// sysBPF 是bpf(2)在amd64上的系统调用号，syscall包中没有定义
+const sysBPF = 321
@@ -0,0 +1,6 @@
+//go:build !amd64 && !arm64
+
+package main
+
+// sysBPF 为0表示不知道bpf(2)在当前架构上的系统调用号，IP地址过滤不可用
+const sysBPF = 0
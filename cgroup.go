@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// cgroupRoot 是cgroup文件系统的挂载点
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupParent 是所有服务cgroup的上级目录名，每个服务使用其下与服务同名的子目录
+const cgroupParent = "systemctl"
+
+// cgroupLimit 是写入服务cgroup的一项控制器设置。
+type cgroupLimit struct {
+	// controller 是控制器名称，如memory；cgroup v1中也是层级的挂载目录名
+	controller string
+	// file 是控制器接口文件名，随cgroup版本不同
+	file  string
+	value string
+}
+
+// cgroupVersion 检测挂载的cgroup版本：2为统一层级，1为按控制器分开的层级，0表示不可用。
+func cgroupVersion() int {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		return 2
+	}
+	if info, err := os.Stat(filepath.Join(cgroupRoot, "memory")); err == nil && info.IsDir() {
+		return 1
+	}
+	return 0
+}
+
+// parseCgroupLimits 解析服务的MemoryMax=（或旧的MemoryLimit=）、CPUQuota=和CPUWeight=，
+// 返回按cgroupVersion的版本需要写入的设置。没有配置任何限制时返回nil。
+func parseCgroupLimits(opts []*unit.UnitOption) ([]cgroupLimit, error) {
+	version := cgroupVersion()
+	var limits []cgroupLimit
+	name := "MemoryMax"
+	val, _ := getOptions(opts, "Service", name)
+	if val == "" {
+		name = "MemoryLimit"
+		val, _ = getOptions(opts, "Service", name)
+	}
+	if val != "" {
+		bytes, err := parseMemoryLimit(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if version == 1 {
+			value := "-1"
+			if bytes != rlimitInfinity {
+				value = strconv.FormatUint(bytes, 10)
+			}
+			limits = append(limits, cgroupLimit{"memory", "memory.limit_in_bytes", value})
+		} else {
+			value := "max"
+			if bytes != rlimitInfinity {
+				value = strconv.FormatUint(bytes, 10)
+			}
+			limits = append(limits, cgroupLimit{"memory", "memory.max", value})
+		}
+	}
+
+	if val, _ := getOptions(opts, "Service", "CPUQuota"); val != "" {
+		quota, err := parseCPUQuota(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPUQuota: %w", err)
+		}
+		if version == 1 {
+			limits = append(limits,
+				cgroupLimit{"cpu", "cpu.cfs_period_us", strconv.Itoa(cpuQuotaPeriod)},
+				cgroupLimit{"cpu", "cpu.cfs_quota_us", strconv.Itoa(quota)})
+		} else {
+			limits = append(limits, cgroupLimit{"cpu", "cpu.max", fmt.Sprintf("%d %d", quota, cpuQuotaPeriod)})
+		}
+	}
+	if weight, err := intOption(opts, "CPUWeight", 1, 10000); err != nil {
+		return nil, err
+	} else if weight != nil {
+		if version == 1 {
+			// 与systemd一致，默认权重100对应cpu.shares的默认值1024
+			limits = append(limits, cgroupLimit{"cpu", "cpu.shares", strconv.Itoa(*weight * 1024 / 100)})
+		} else {
+			limits = append(limits, cgroupLimit{"cpu", "cpu.weight", strconv.Itoa(*weight)})
+		}
+	}
+	return limits, nil
+}
+
+// cpuQuotaPeriod 是CPUQuota=使用的调度周期，单位为微秒
+const cpuQuotaPeriod = 100000
+
+// parseCPUQuota 解析CPUQuota=的百分比，返回每个调度周期内可用的CPU时间（微秒）。
+// 超过100%表示可以使用多个CPU，如200%为两个CPU。
+func parseCPUQuota(val string) (int, error) {
+	percent, ok := strings.CutSuffix(val, "%")
+	if !ok {
+		return 0, fmt.Errorf("%s is not a percentage", val)
+	}
+	p, err := strconv.ParseFloat(percent, 64)
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid percentage: %s", val)
+	}
+	// 内核要求配额至少为1毫秒
+	return max(int(p*cpuQuotaPeriod/100), 1000), nil
+}
+
+// parseMemoryLimit 解析内存限制：infinity、带K、M、G、T（1024进制）后缀的字节数，
+// 或物理内存的百分比（如50%）。
+func parseMemoryLimit(val string) (uint64, error) {
+	if percent, ok := strings.CutSuffix(val, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return 0, fmt.Errorf("invalid percentage: %s", val)
+		}
+		var info syscall.Sysinfo_t
+		if err = syscall.Sysinfo(&info); err != nil {
+			return 0, err
+		}
+		return uint64(float64(info.Totalram) * float64(info.Unit) * p / 100), nil
+	}
+	return parseRlimitValue(val)
+}
+
+// cgroupDirs 返回服务cgroup的目录：cgroup v2只有一个，v1中每个控制器各一个。
+func cgroupDirs(service string, version int, limits []cgroupLimit) []string {
+	if version == 2 {
+		return []string{filepath.Join(cgroupRoot, cgroupParent, service)}
+	}
+	var dirs []string
+	for _, limit := range limits {
+		dir := filepath.Join(cgroupRoot, limit.controller, cgroupParent, service)
+		if len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// placeInCgroup 为服务创建cgroup、写入limits并挂载IP地址过滤程序，使命令启动时就位于这些cgroup中，
+// 这样进程在exec之前和之后派生的所有子进程都受限制。返回的函数在命令启动之后调用，释放准备时打开的文件。
+// 在没有权限的容器中通常无法创建cgroup或加载过滤程序，此时只记录日志，服务在没有相应限制的情况下运行。
+func placeInCgroup(service string, command *exec.Cmd, limits []cgroupLimit, filter *ipFilter) (release func()) {
+	release = func() {}
+	// v1Dirs 是cgroup v1中需要经由exec-cgroup加入的目录，unifiedDir 是cgroup v2中在clone时直接加入的目录
+	var v1Dirs []string
+	var unifiedDir string
+	if len(limits) > 0 {
+		version := cgroupVersion()
+		dirs, err := createCgroup(service, version, limits)
+		switch {
+		case err != nil:
+			log.Printf("Resource control is not available, %s will run without limits: %v\n", service, err)
+		case version == 2:
+			unifiedDir = dirs[0]
+		default:
+			v1Dirs = dirs
+		}
+	}
+	if filter != nil {
+		// 统一层级中与资源限制使用同一个cgroup，混合层级中使用cgroup v2部分的同名cgroup
+		dir, err := installIPFilter(service, filter)
+		if err != nil {
+			log.Printf("IP address filtering is not available, %s will run unfiltered: %v\n", service, err)
+		} else {
+			unifiedDir = dir
+		}
+	}
+	if len(v1Dirs) > 0 {
+		if err := cgroupExec(command, v1Dirs); err != nil {
+			log.Printf("Resource control is not available, %s will run without limits: %v\n", service, err)
+		}
+	}
+	if unifiedDir == "" {
+		return release
+	}
+	// cgroup v2可以在clone时直接将子进程创建在目标cgroup中，不受User=的影响
+	dir, err := os.Open(unifiedDir)
+	if err != nil {
+		log.Printf("Failed to place %s in cgroup %s, it will run without its limits: %v\n", service, unifiedDir, err)
+		return release
+	}
+	command.SysProcAttr.UseCgroupFD = true
+	command.SysProcAttr.CgroupFD = int(dir.Fd())
+	return func() { _ = dir.Close() }
+}
+
+// createCgroup 创建服务的cgroup并写入limits，返回需要加入的cgroup目录。
+func createCgroup(service string, version int, limits []cgroupLimit) ([]string, error) {
+	switch version {
+	case 0:
+		return nil, errors.New("no cgroup filesystem mounted at " + cgroupRoot)
+	case 2:
+		if err := enableControllers(limits); err != nil {
+			return nil, err
+		}
+	}
+	dirs := cgroupDirs(service, version, limits)
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	for _, limit := range limits {
+		dir := cgroupDirs(service, version, []cgroupLimit{limit})[0]
+		if err := os.WriteFile(filepath.Join(dir, limit.file), []byte(limit.value), 0644); err != nil {
+			return nil, fmt.Errorf("set %s: %w", limit.file, err)
+		}
+	}
+	return dirs, nil
+}
+
+// unifiedCgroupRoot 返回cgroup v2层级的挂载点：统一层级中就是cgroupRoot，
+// 混合层级中通常挂载在其下的unified目录。没有cgroup v2层级时返回""。
+func unifiedCgroupRoot() string {
+	for _, dir := range []string{cgroupRoot, filepath.Join(cgroupRoot, "unified")} {
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// daemonCgroup 是cgroup v2中守护进程所在的叶子cgroup，与systemd的init.scope同名
+const daemonCgroup = "init.scope"
+
+// enableControllers 在cgroupRoot和服务的上级cgroup中启用limits所需的控制器，使服务的cgroup可以使用它们。
+// 按cgroup v2的"无内部进程"规则，非根cgroup中有进程时不能向子cgroup分配控制器（EBUSY）。
+// 容器中cgroupRoot通常就是守护进程自己所在的cgroup，因此先把其中的进程移入叶子cgroup daemonCgroup再重试。
+func enableControllers(limits []cgroupLimit) error {
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	for _, limit := range limits {
+		for _, dir := range []string{cgroupRoot, parent} {
+			control := filepath.Join(dir, "cgroup.subtree_control")
+			err := os.WriteFile(control, []byte("+"+limit.controller), 0644)
+			if errors.Is(err, syscall.EBUSY) && dir == cgroupRoot {
+				if err = evacuateRootCgroup(); err == nil {
+					err = os.WriteFile(control, []byte("+"+limit.controller), 0644)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("enable %s controller: %w", limit.controller, err)
+			}
+		}
+	}
+	return nil
+}
+
+// evacuateRootCgroup 将cgroupRoot中的所有进程（守护进程自身和未受资源限制的服务）移入daemonCgroup。
+// 已经退出或无法移动的进程（如内核线程）会被跳过。
+func evacuateRootCgroup() error {
+	leaf := filepath.Join(cgroupRoot, daemonCgroup)
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(cgroupRoot, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(data)) {
+		_ = os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0644)
+	}
+	log.Printf("Moved processes of %s into %s to enable resource controllers\n", cgroupRoot, leaf)
+	return nil
+}
+
+// cgroupExec 使命令经由"systemctl exec-cgroup"中转：它先把自身移入dirs中的cgroup，再exec真正的程序。
+// cgroup v1不支持在创建进程时指定cgroup，因此使用这种方式。设置了User=/Group=时，
+// 中转进程已经以服务的用户身份运行，需要把cgroup.procs交给该用户才能加入。
+func cgroupExec(command *exec.Cmd, dirs []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if credential := command.SysProcAttr.Credential; credential != nil {
+		for _, dir := range dirs {
+			if err = os.Chown(filepath.Join(dir, "cgroup.procs"), int(credential.Uid), int(credential.Gid)); err != nil {
+				return err
+			}
+		}
+	}
+	args := append([]string{"systemctl", "exec-cgroup"}, dirs...)
+	command.Args = append(append(args, "--", command.Path), command.Args...)
+	command.Path = self
+	return nil
+}
+
+// execCgroup 是exec-cgroup子命令的实现，args为cgroup目录、"--"、目标程序路径和完整的argv。
+// 无法加入cgroup时在标准错误中报告，服务仍在没有资源限制的情况下运行。
+func execCgroup(args []string) error {
+	sep := slices.Index(args, "--")
+	if sep < 0 || len(args) < sep+3 {
+		return errors.New("usage: systemctl exec-cgroup <dir>... -- <path> <argv...>")
+	}
+	pid := strconv.Itoa(os.Getpid())
+	for _, dir := range args[:sep] {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(pid), 0644); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to join cgroup %s, running without limits: %v\n", dir, err)
+		}
+	}
+	return syscall.Exec(args[sep+1], args[sep+2:], os.Environ())
+}
+
+// cgroupControllers 是服务cgroup可能使用的cgroup v1控制器
+var cgroupControllers = []string{"memory", "cpu"}
+
+// removeCgroup 在服务停止后删除其cgroup，挂载在上面的IP地址过滤程序随之释放。
+// cgroup中仍有进程或从未创建时删除失败，直接忽略。
+func removeCgroup(service string) {
+	_ = os.Remove(filepath.Join(cgroupRoot, cgroupParent, service))
+	_ = os.Remove(filepath.Join(cgroupRoot, "unified", cgroupParent, service))
+	for _, controller := range cgroupControllers {
+		_ = os.Remove(filepath.Join(cgroupRoot, controller, cgroupParent, service))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// collectMode 解析[Unit]中的CollectMode=：inactive（默认）只回收非失败状态的单元，
+// inactive-or-failed时失败的单元也会被回收。无法识别的值按默认值处理。
+func collectMode(opts []*unit.UnitOption) string {
+	val, _ := getOptions(opts, "Unit", "CollectMode")
+	switch val {
+	case "", "inactive":
+		return "inactive"
+	case "inactive-or-failed":
+		return val
+	default:
+		log.Printf("Invalid CollectMode=%s, using inactive\n", val)
+		return "inactive"
+	}
+}
+
+// collectUnit 在服务不再需要时删除守护进程为它保存的运行时状态：单元文件已被删除（单元不再加载）
+// 且服务没有在运行。按服务最近一次启动时的CollectMode=，失败的服务默认保留以便排查，
+// 直到reset-failed清除失败状态；inactive-or-failed时失败的服务也会被回收。
+// 返回是否回收了该服务。调用方必须持有lock。
+func collectUnit(service string) bool {
+	state := mapState[service]
+	if state == nil || isCommandRunning(service, mapCommand[service]) || find(service) != "" {
+		return false
+	}
+	if (state.failed || state.startLimitHit) && state.collectMode != "inactive-or-failed" {
+		return false
+	}
+	delete(mapState, service)
+	delete(mapCommand, service)
+	log.Printf("Collected unit %s.service\n", service)
+	return true
+}
+
+// collectUnits 回收所有不再需要的服务，返回被回收的服务名称。调用方必须持有lock。
+func collectUnits() []string {
+	var collected []string
+	for service := range mapState {
+		if collectUnit(service) {
+			collected = append(collected, service)
+		}
+	}
+	sort.Strings(collected)
+	return collected
+}
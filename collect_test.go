@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCollectMode(t *testing.T) {
+	tests := []struct {
+		mode         string
+		keepsFailed  bool
+		wantProperty string
+	}{
+		{"", true, "CollectMode=inactive"},
+		{"inactive", true, "CollectMode=inactive"},
+		{"inactive-or-failed", false, "CollectMode=inactive-or-failed"},
+	}
+	for _, tt := range tests {
+		t.Run("mode="+tt.mode, func(t *testing.T) {
+			setupTestPaths(t)
+			unitSection := "[Unit]\n"
+			if tt.mode != "" {
+				unitSection += "CollectMode=" + tt.mode + "\n"
+			}
+			writeUnit(t, "failing.service", unitSection+"[Service]\nExecStartPre=/bin/false\nExecStart=/bin/sleep 60\n")
+			writeUnit(t, "passing.service", unitSection+"[Service]\nRestart=on-failure\nExecStart=/bin/true\n")
+			if err := Start("failing"); err == nil {
+				t.Fatal("start of failing unit succeeded")
+			}
+			if err := Start("passing"); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, 5*time.Second, "passing unit to exit", func() bool {
+				lock.Lock()
+				defer lock.Unlock()
+				return !mapState["passing"].inactiveEnter.IsZero()
+			})
+			lock.Lock()
+			properties := serviceProperties("failing", nil, resourceUsage{})
+			lock.Unlock()
+			if !slices.Contains(properties, tt.wantProperty) {
+				t.Errorf("properties %q do not contain %s", properties, tt.wantProperty)
+			}
+
+			// 单元文件仍在时不回收
+			if _, err := DaemonReload(false); err != nil {
+				t.Fatal(err)
+			}
+			if !hasState("failing") || !hasState("passing") {
+				t.Fatal("units with unit files were collected")
+			}
+
+			for _, name := range []string{"failing.service", "passing.service"} {
+				if err := os.Remove(filepath.Join(localUnitPath, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			out, err := DaemonReload(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hasState("passing") {
+				t.Error("inactive unit without a unit file was not collected")
+			}
+			if got := hasState("failing"); got != tt.keepsFailed {
+				t.Errorf("failed unit kept = %v, want %v\n%s", got, tt.keepsFailed, out)
+			}
+
+			if tt.keepsFailed {
+				if err := ResetFailed("failing"); err != nil {
+					t.Fatal(err)
+				}
+				if hasState("failing") {
+					t.Error("failed unit was not collected after reset-failed")
+				}
+			}
+		})
+	}
+}
+
+// hasState 判断守护进程是否还保存着服务的运行时状态。
+func hasState(service string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return mapState[service] != nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// errConditionFailed 表示服务的Condition*=不满足，与systemd一致跳过启动而不视为失败
+var errConditionFailed = errors.New("condition failed, skipped")
+
+// conditionChecks 是支持的Condition*=和Assert*=检查，键为去掉Condition或Assert前缀后的名称，
+// 参数为去掉"|"和"!"前缀后的值
+var conditionChecks = map[string]func(string) bool{
+	"PathExists": func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	},
+	"PathIsDirectory": func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.IsDir()
+	},
+	"FileNotEmpty": func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().IsRegular() && info.Size() > 0
+	},
+	"Host": func(pattern string) bool {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return false
+		}
+		ok, _ := filepath.Match(pattern, hostname)
+		return ok
+	},
+}
+
+// unmetCondition 按顺序检查[Unit]中以prefix开头的条件，返回第一个不满足的条件，全部满足时返回空字符串。
+// 值以"!"开头表示取反；以"|"开头的是触发条件，存在触发条件时至少要满足其中一个，其余条件必须全部满足。
+func unmetCondition(opts []*unit.UnitOption, prefix string) string {
+	var triggers []string
+	triggered := false
+	for _, opt := range opts {
+		if opt.Section != "Unit" || !strings.HasPrefix(opt.Name, prefix) || opt.Value == "" {
+			continue
+		}
+		check := conditionChecks[strings.TrimPrefix(opt.Name, prefix)]
+		if check == nil {
+			log.Printf("%s= is not supported, ignoring it\n", opt.Name)
+			continue
+		}
+		val := opt.Value
+		trigger := strings.HasPrefix(val, "|")
+		val = strings.TrimPrefix(val, "|")
+		negate := strings.HasPrefix(val, "!")
+		val = strings.TrimPrefix(val, "!")
+
+		desc := opt.Name + "=" + opt.Value
+		ok := check(val) != negate
+		if trigger {
+			triggers = append(triggers, desc)
+			triggered = triggered || ok
+			continue
+		}
+		if !ok {
+			return desc
+		}
+	}
+	if len(triggers) > 0 && !triggered {
+		return strings.Join(triggers, " or ")
+	}
+	return ""
+}
+
+// checkConditions 检查服务的Condition*=，不满足时记录在服务状态中并返回包装了errConditionFailed的错误。
+// 调用方必须持有lock。
+func checkConditions(service string, opts []*unit.UnitOption) error {
+	state := getState(service)
+	state.condition = unmetCondition(opts, "Condition")
+	if state.condition != "" {
+		return fmt.Errorf("%w: %s", errConditionFailed, state.condition)
+	}
+	return nil
+}
+
+// checkAsserts 检查服务的Assert*=。与Condition*=不同，断言不满足时启动失败，服务进入failed状态。
+// 调用方必须持有lock。
+func checkAsserts(service string, opts []*unit.UnitOption) error {
+	state := getState(service)
+	state.assert = unmetCondition(opts, "Assert")
+	if state.assert != "" {
+		state.failed = true
+		state.result = "assert"
+		state.inactiveEnter = time.Now()
+		return fmt.Errorf("assertion failed for %s.service: %s", service, state.assert)
+	}
+	return nil
+}
+
+// runExecCondition 依次执行ExecCondition=命令：全部以0退出时继续启动；以1到254退出时
+// 与Condition*=一样跳过启动，返回包装了errConditionFailed的错误；以255退出、被信号终止
+// 或无法执行时启动失败，结果为exec-condition。调用方必须持有服务的锁和lock，等待命令退出期间释放lock。
+func runExecCondition(service string, opts []*unit.UnitOption, execCtx *execContext) error {
+	state := getState(service)
+	for _, val := range getAllOptions(opts, "Service", "ExecCondition") {
+		cmd, err := execCtx.command(val)
+		if err == nil {
+			log.Printf("Running ExecCondition for %s: %s\n", service, cmd.String())
+			if err = startWaited(cmd); err == nil {
+				unlocked(func() { err = cmd.Wait() })
+				doneWaiting(cmd)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 1 && exitErr.ExitCode() <= 254 {
+			state.condition = "ExecCondition=" + val
+			return fmt.Errorf("%w: ExecCondition=%s exited with code %d", errConditionFailed, val, exitErr.ExitCode())
+		}
+		state.failed = true
+		state.result = "exec-condition"
+		state.inactiveEnter = time.Now()
+		return fmt.Errorf("ExecCondition failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+const (
+	// defaultCrashLoopInterval 是X-CrashLoopIntervalSec=的默认值
+	defaultCrashLoopInterval = 5 * time.Minute
+	// defaultCrashLoopMinUptime 是X-CrashLoopMinUptimeSec=的默认值
+	defaultCrashLoopMinUptime = 10 * time.Second
+)
+
+// crashLoopPolicy 是服务的崩溃循环检测配置。
+type crashLoopPolicy struct {
+	// burst 是X-CrashLoopBurst=，interval内崩溃超过这么多次视为崩溃循环，为0表示不检测
+	burst int
+	// interval 是X-CrashLoopIntervalSec=，统计崩溃次数的时间窗口
+	interval time.Duration
+	// minUptime 是X-CrashLoopMinUptimeSec=，运行时间不足它就退出的实例才算一次崩溃，
+	// 稳定运行过的服务偶尔退出不会被误判
+	minUptime time.Duration
+	// disable 是X-CrashLoopDisable=，为true时检测到崩溃循环后禁用服务，下次守护进程启动时不再自动启动它
+	disable bool
+}
+
+// parseCrashLoopPolicy 读取单元的崩溃循环检测配置，未设置X-CrashLoopBurst=时burst为0。
+func parseCrashLoopPolicy(service string, opts []*unit.UnitOption) crashLoopPolicy {
+	policy := crashLoopPolicy{interval: defaultCrashLoopInterval, minUptime: defaultCrashLoopMinUptime}
+	if val, _ := getOptions(opts, "Service", "X-CrashLoopBurst"); val != "" {
+		if n, err := strconv.Atoi(val); err != nil || n < 0 {
+			log.Printf("Invalid X-CrashLoopBurst for %s: %s\n", service, val)
+		} else {
+			policy.burst = n
+		}
+	}
+	for _, option := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"X-CrashLoopIntervalSec", &policy.interval},
+		{"X-CrashLoopMinUptimeSec", &policy.minUptime},
+	} {
+		val, _ := getOptions(opts, "Service", option.name)
+		if val == "" {
+			continue
+		}
+		if d, err := parseTimeSpan(val); err != nil || d <= 0 {
+			log.Printf("Invalid %s for %s: %s\n", option.name, service, val)
+		} else {
+			*option.dst = d
+		}
+	}
+	val, _ := getOptions(opts, "Service", "X-CrashLoopDisable")
+	switch val {
+	case "yes", "true", "on", "1":
+		policy.disable = true
+	}
+	return policy
+}
+
+// detectCrashLoop 在服务实例退出、即将自动重启时调用，记录运行时间不足X-CrashLoopMinUptimeSec的退出。
+// X-CrashLoopIntervalSec内这样的退出超过X-CrashLoopBurst次时，将服务标记为失败并返回true，
+// 此后服务不再自动重启，手动启动也会被拒绝，直到执行reset-failed。
+// 设置了X-CrashLoopDisable=yes时同时禁用服务。调用方不能持有lock。
+func detectCrashLoop(service string, opts []*unit.UnitOption) bool {
+	policy := parseCrashLoopPolicy(service, opts)
+	if policy.burst == 0 {
+		return false
+	}
+
+	lock.Lock()
+	state := getState(service)
+	uptime := state.inactiveEnter.Sub(state.activeEnter)
+	if state.activeEnter.IsZero() || uptime >= policy.minUptime {
+		lock.Unlock()
+		return false
+	}
+	now := time.Now()
+	crashes := state.crashes[:0]
+	for _, t := range state.crashes {
+		if now.Sub(t) < policy.interval {
+			crashes = append(crashes, t)
+		}
+	}
+	state.crashes = append(crashes, now)
+	if len(state.crashes) <= policy.burst {
+		lock.Unlock()
+		return false
+	}
+	crashLoop := fmt.Sprintf("%d crashes within %v", len(state.crashes), policy.interval)
+	state.crashLoop = crashLoop
+	state.failed = true
+	state.result = "crash-loop"
+	lock.Unlock()
+
+	log.Printf("WARNING: Service %s is crash looping (%s, each running less than %v), giving up restarting it\n",
+		service, crashLoop, policy.minUptime)
+	emit(service, "failed", "Result: crash-loop")
+	if !policy.disable {
+		return true
+	}
+	if err := Disable(service); err != nil {
+		log.Printf("WARNING: Failed to disable crash looping service %s: %v\n", service, err)
+		return true
+	}
+	log.Printf("WARNING: Disabled crash looping service %s, it will not be started at boot until it is enabled again\n", service)
+	lock.Lock()
+	state.crashLoopDisabled = true
+	lock.Unlock()
+	return true
+}
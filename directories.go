@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// checkWorkingDirectory 确认工作目录存在且是目录，避免进程启动时出现难以理解的错误。
+func checkWorkingDirectory(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("WorkingDirectory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("WorkingDirectory %s is not a directory", dir)
+	}
+	return nil
+}
+
+// runtimeDirectories 返回RuntimeDirectory=配置的目录在runtimePath下的完整路径。
+func runtimeDirectories(opts []*unit.UnitOption) []string {
+	var dirs []string
+	for _, val := range getAllOptions(opts, "Service", "RuntimeDirectory") {
+		for _, name := range strings.Fields(val) {
+			dirs = append(dirs, filepath.Join(runtimePath, name))
+		}
+	}
+	return dirs
+}
+
+// createRuntimeDirectories 按RuntimeDirectoryMode=（默认0755）创建服务的运行时目录，
+// 并将其属主设为服务的运行用户。返回的路径用于设置RUNTIME_DIRECTORY环境变量。
+func createRuntimeDirectories(opts []*unit.UnitOption, execCtx *execContext) ([]string, error) {
+	dirs := runtimeDirectories(opts)
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+	mode := os.FileMode(0755)
+	if val, _ := getOptions(opts, "Service", "RuntimeDirectoryMode"); val != "" {
+		m, err := strconv.ParseUint(val, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RuntimeDirectoryMode: %s", val)
+		}
+		mode = os.FileMode(m)
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, mode); err != nil {
+			return nil, err
+		}
+		// MkdirAll受umask影响，显式设置权限
+		if err := os.Chmod(dir, mode); err != nil {
+			return nil, err
+		}
+		if execCtx.credential != nil {
+			if err := os.Chown(dir, int(execCtx.credential.Uid), int(execCtx.credential.Gid)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// removeRuntimeDirectories 在服务停止后删除其运行时目录，RuntimeDirectoryPreserve=yes时保留。
+func removeRuntimeDirectories(service string, opts []*unit.UnitOption) {
+	if val, _ := getOptions(opts, "Service", "RuntimeDirectoryPreserve"); val == "yes" {
+		return
+	}
+	for _, dir := range runtimeDirectories(opts) {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to remove runtime directory of %s: %v\n", service, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// dropInDir 返回服务在localUnitPath下的drop-in目录（<service>.service.d）。
+func dropInDir(service string) string {
+	return fmt.Sprintf("%s/%s.service.d", localUnitPath, service)
+}
+
+// loadDropIns 读取服务drop-in目录中的*.conf片段，返回合并后追加在基础单元之后的选项。
+// 与systemd一致，每个单元目录下的<service>.service.d都会被读取，同名片段以优先级高的目录中的为准，
+// 所有片段按文件名字典序应用，后应用的值优先。目录不存在时返回空列表。
+// 模板实例同时读取模板（<template>.service.d）和实例自己的目录。
+func loadDropIns(service string) ([]*unit.UnitOption, error) {
+	names := []string{service}
+	if template, _, ok := splitInstance(service); ok {
+		names = []string{template, service}
+	}
+	// 从优先级最低的目录开始读取，使优先级高的目录中的同名片段覆盖它
+	dirs := unitDirs()
+	byName := map[string]string{}
+	for _, name := range names {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			root := dirs[i]
+			matches, err := filepath.Glob(filepath.Join(root, name+".service.d", "*.conf"))
+			if err != nil {
+				return nil, err
+			}
+			for _, match := range matches {
+				byName[filepath.Base(match)] = match
+			}
+		}
+	}
+	bases := make([]string, 0, len(byName))
+	for base := range byName {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	var files []string
+	for _, base := range bases {
+		files = append(files, byName[base])
+	}
+
+	var opts []*unit.UnitOption
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		fragment, err := parseSystemdService(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		opts = append(opts, fragment...)
+	}
+	return opts, nil
+}
+
+// Edit 将content写入服务的drop-in覆盖文件override.conf并返回文件路径。
+// 写入前会校验内容可以被解析，新配置在下次启动或重启服务时生效。
+func Edit(service, content string) (string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if find(service) == "" {
+		return "", fmt.Errorf("no service found")
+	}
+	if _, err := parseSystemdService(content); err != nil {
+		return "", err
+	}
+	dir := dropInDir(service)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "override.conf")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	log.Printf("Wrote drop-in override for %s: %s\n", service, path)
+	return path, nil
+}
+
+// editOverride 在编辑器中打开服务的override.conf供用户修改，返回编辑后的内容。
+// 编辑器依次取$VISUAL、$EDITOR，都未设置时使用vi；文件尚不存在时以空的[Service]段开始。
+// 编辑的是临时副本，实际写入仍通过守护进程完成，以便校验内容。
+// 内容未改变或为空时返回nil，表示取消编辑。
+func editOverride(service string) ([]byte, error) {
+	initial := []byte("[Service]\n")
+	if data, err := os.ReadFile(filepath.Join(dropInDir(service), "override.conf")); err == nil {
+		initial = data
+	}
+	tmp, err := os.CreateTemp("", service+"-override-*.conf")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	_, err = tmp.Write(initial)
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// 编辑器设置中可以带参数（如"code --wait"），交给shell拆分
+	command := exec.Command("/bin/sh", "-c", editor+` "$1"`, editor, tmp.Name())
+	command.Stdin, command.Stdout, command.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = command.Run(); err != nil {
+		return nil, fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+	content, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(content)) == "" || string(content) == string(initial) {
+		return nil, nil
+	}
+	return content, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestEditStdin 检查通过守护进程消息写入的drop-in内容被写入override.conf，并在启动服务时生效。
+func TestEditStdin(t *testing.T) {
+	dir := setupTestPaths(t)
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "greet.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho ${GREETING:-unset} > "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "web.service", "[Service]\nRestart=on-failure\nExecStart="+script+"\n")
+
+	content := "[Service]\nEnvironment=GREETING=hello\n"
+	path, err := handleMessage("edit:web.service\n" + content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dropInDir("web"), "override.conf"); path != want {
+		t.Errorf("edit returned %q, want %s", path, want)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != content {
+		t.Fatalf("override.conf = %q, %v; want %q", data, err, content)
+	}
+
+	if err = Start("web"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "service output", func() bool {
+		data, _ := os.ReadFile(out)
+		return len(data) > 0
+	})
+	if data, _ := os.ReadFile(out); string(data) != "hello\n" {
+		t.Errorf("service output = %q, want the drop-in environment applied", data)
+	}
+
+	if _, err = handleMessage("edit:web\n[Service\nEnvironment=GREETING=broken\n"); err == nil {
+		t.Error("edit accepted content that does not parse")
+	}
+	if data, _ := os.ReadFile(path); string(data) != content {
+		t.Errorf("invalid edit overwrote override.conf with %q", data)
+	}
+	if _, err = handleMessage("edit:missing\n" + content); err == nil {
+		t.Error("edit of a unit that does not exist succeeded")
+	}
+}
+
+// TestEditOverride 检查交互式编辑以现有的override.conf为起点，未修改或清空内容时取消编辑。
+func TestEditOverride(t *testing.T) {
+	dir := setupTestPaths(t)
+	appender := filepath.Join(dir, "append.sh")
+	if err := os.WriteFile(appender, []byte("#!/bin/sh\necho \"$1\" >> \"$2\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", "")
+
+	// 编辑器设置中的参数在文件名之前传入
+	t.Setenv("EDITOR", appender+" Environment=GREETING=hi")
+	content, err := editOverride("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[Service]\nEnvironment=GREETING=hi\n"; string(content) != want {
+		t.Errorf("edited content = %q, want %q", content, want)
+	}
+
+	existing := "[Service]\nEnvironment=GREETING=hello\n"
+	if err = os.MkdirAll(dropInDir("web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dropInDir("web"), "override.conf"), []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", appender+" User=nobody")
+	if content, err = editOverride("web"); err != nil {
+		t.Fatal(err)
+	}
+	if want := existing + "User=nobody\n"; string(content) != want {
+		t.Errorf("edited content = %q, want %q", content, want)
+	}
+
+	for _, editor := range []string{"true", ": >"} {
+		t.Setenv("VISUAL", editor)
+		if content, err = editOverride("web"); err != nil || content != nil {
+			t.Errorf("editor %q: got %q, %v; want the edit cancelled", editor, content, err)
+		}
+	}
+	t.Setenv("VISUAL", "false")
+	if _, err = editOverride("web"); err == nil {
+		t.Error("failing editor did not return an error")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// DryRun 描述op（start、stop或restart）会对服务执行的操作：要运行的命令、工作目录、
+// 环境变量和要终止的进程，但不启动或终止任何进程，用于在真正运行前检查单元文件。
+func DryRun(op, service string) (string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if isMasked(service) {
+		return "", errMasked(service)
+	}
+	opts, err := loadUnit(service)
+	if err != nil {
+		return "", err
+	}
+	command := mapCommand[service]
+	running := isCommandRunning(service, command)
+
+	var lines []string
+	if op == "stop" || op == "restart" {
+		if running {
+			lines = append(lines, describeStop(service, command.Process.Pid, opts)...)
+		} else {
+			lines = append(lines, "Service is not running, nothing to stop")
+		}
+	}
+	if op == "start" && running {
+		lines = append(lines, fmt.Sprintf("Service is already running (PID: %d), nothing to start", command.Process.Pid))
+	} else if op == "start" || op == "restart" {
+		start, err2 := describeStart(service, opts)
+		if err2 != nil {
+			return "", err2
+		}
+		lines = append(lines, start...)
+	}
+
+	res := strings.Join(lines, "\n")
+	log.Printf("Dry run of %s %s:\n%s\n", op, service, res)
+	return res, nil
+}
+
+// describeStart 描述启动服务时会执行的操作。Exec*命令无法解析时返回错误。调用方必须持有lock。
+func describeStart(service string, opts []*unit.UnitOption) ([]string, error) {
+	serviceType, _ := getOptions(opts, "Service", "Type")
+	if serviceType == "" {
+		serviceType = "simple"
+	}
+	execStarts := getAllOptions(opts, "Service", "ExecStart")
+	if len(execStarts) == 0 {
+		return nil, errors.New("ExecStart not found")
+	}
+	execCtx, err := newExecContext(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkWorkingDirectory(execCtx.dir); err != nil {
+		return nil, err
+	}
+
+	lines := []string{fmt.Sprintf("Would start %s.service (Type=%s)", service, serviceType)}
+	for _, dep := range unitNames(append(getAllOptions(opts, "Unit", "Requires"), getAllOptions(opts, "Unit", "Wants")...)) {
+		if !isCommandRunning(dep, mapCommand[dep]) {
+			lines = append(lines, "Would start dependency: "+dep)
+		}
+	}
+	for _, conflict := range unitNames(getAllOptions(opts, "Unit", "Conflicts")) {
+		if isCommandRunning(conflict, mapCommand[conflict]) {
+			lines = append(lines, "Would stop conflicting service: "+conflict)
+		}
+	}
+	dir := execCtx.dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	lines = append(lines, "WorkingDirectory="+dir)
+	if execCtx.credential != nil {
+		lines = append(lines, fmt.Sprintf("UID=%d GID=%d", execCtx.credential.Uid, execCtx.credential.Gid))
+	}
+	// 只列出单元和管理器设置的变量，守护进程自身的环境不再重复
+	for _, kv := range execCtx.env[len(os.Environ()):] {
+		lines = append(lines, "Environment: "+kv)
+	}
+	for _, directive := range []string{"ExecStartPre", "ExecStart", "ExecStartPost"} {
+		for _, val := range getAllOptions(opts, "Service", directive) {
+			cmd, err2 := execCtx.command(val)
+			if err2 != nil {
+				return nil, fmt.Errorf("%s: %w", directive, err2)
+			}
+			lines = append(lines, directive+": "+cmd.String())
+		}
+	}
+	return lines, nil
+}
+
+// describeStop 描述停止服务时会执行的ExecStop命令和发送的信号。
+func describeStop(service string, pid int, opts []*unit.UnitOption) []string {
+	killSignal := "SIGTERM"
+	if val, _ := getOptions(opts, "Service", "KillSignal"); val != "" {
+		if sig, err := parseSignal(val); err == nil {
+			killSignal = signalName(sig)
+		} else {
+			killSignal = val + " (invalid)"
+		}
+	}
+	killMode := "control-group"
+	if val, _ := getOptions(opts, "Service", "KillMode"); val != "" {
+		killMode = val
+	}
+	lines := []string{fmt.Sprintf("Would stop %s.service (PID: %d)", service, pid)}
+	if execCtx, err := newExecContext(opts); err == nil {
+		for _, val := range getAllOptions(opts, "Service", "ExecStop") {
+			if cmd, err2 := execCtx.command(val); err2 == nil {
+				lines = append(lines, "ExecStop: "+cmd.String())
+			} else {
+				lines = append(lines, fmt.Sprintf("ExecStop: %v", err2))
+			}
+		}
+	}
+	if killMode != "none" {
+		lines = append(lines, fmt.Sprintf("Would send %s (KillMode=%s), SIGKILL after %v",
+			killSignal, killMode, timeoutOption(opts, "TimeoutStopSec", defaultTimeoutStopSec)))
+	}
+	return lines
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// managerEnv 是守护进程维护的管理器环境变量，会合并到每个新启动服务的环境中。
+// 受lock保护。
+var managerEnv = map[string]string{}
+
+// managerEnviron 以KEY=VALUE列表的形式返回管理器环境，按变量名排序。调用方必须持有lock。
+func managerEnviron() []string {
+	env := make([]string, 0, len(managerEnv))
+	for key, val := range managerEnv {
+		env = append(env, key+"="+val)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// ShowEnvironment 返回管理器环境，每行一个KEY=VALUE。
+func ShowEnvironment() string {
+	lock.Lock()
+	defer lock.Unlock()
+	return strings.Join(managerEnviron(), "\n")
+}
+
+// SetEnvironment 设置一组KEY=VALUE形式的管理器环境变量，对之后启动的服务生效。
+func SetEnvironment(assignments []string) error {
+	for _, kv := range assignments {
+		if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+			return fmt.Errorf("invalid environment assignment: %s", kv)
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, kv := range assignments {
+		key, val, _ := strings.Cut(kv, "=")
+		managerEnv[key] = val
+	}
+	return nil
+}
+
+// UnsetEnvironment 从管理器环境中删除指定的变量。
+func UnsetEnvironment(keys []string) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, key := range keys {
+		delete(managerEnv, key)
+	}
+}
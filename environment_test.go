@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestManagerEnvironment 检查set-environment设置的变量被之后启动的服务继承，
+// 单元自己的Environment=优先于管理器环境。
+func TestManagerEnvironment(t *testing.T) {
+	dir := setupTestPaths(t)
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "env.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho ${GREETING:-unset} $SHADOWED > \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "inherit.service", "[Service]\nRestart=on-failure\nEnvironment=SHADOWED=unit\nExecStart="+script+" "+out+"\n")
+	run := func() string {
+		t.Helper()
+		_ = os.Remove(out)
+		if err := Start("inherit"); err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		waitFor(t, 5*time.Second, "service output", func() bool {
+			var err error
+			data, err = os.ReadFile(out)
+			return err == nil && len(data) > 0
+		})
+		waitFor(t, 5*time.Second, "service to exit", func() bool { return !isRunning("inherit") })
+		return string(data)
+	}
+
+	if _, err := handleMessage("set-environment:\nGREETING=hello\nSHADOWED=manager"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ShowEnvironment(); got != "GREETING=hello\nSHADOWED=manager" {
+		t.Errorf("show-environment = %q", got)
+	}
+	if got := run(); got != "hello unit\n" {
+		t.Errorf("service output = %q, want the manager environment with the unit's override", got)
+	}
+
+	if _, err := handleMessage("unset-environment:\nGREETING"); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); got != "unset unit\n" {
+		t.Errorf("service output after unset-environment = %q", got)
+	}
+	if _, err := handleMessage("set-environment:\nnoequals"); err == nil {
+		t.Error("set-environment accepted an assignment without =")
+	}
+}
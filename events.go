@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// unitEvent 是服务的一次状态变化，如started、exited、restarting、failed、stopped，
+// 以及停止过程中未响应KillSignal时的not-responding。
+type unitEvent struct {
+	time    time.Time
+	service string
+	event   string
+	detail  string
+}
+
+// String 以"时间 服务: 事件 (详情)"的格式描述事件。
+func (e unitEvent) String() string {
+	s := fmt.Sprintf("%s %s.service: %s", formatTimestamp(e.time), e.service, e.event)
+	if e.detail != "" {
+		s += " (" + e.detail + ")"
+	}
+	return s
+}
+
+var (
+	// observers 记录订阅了状态变化的通道及其关注的服务
+	observers = map[chan unitEvent]string{}
+	// observerLock 保护observers，emit在持有lock时也会被调用，因此不复用lock
+	observerLock sync.Mutex
+)
+
+// observerBuffer 是每个订阅者缓存的事件数，客户端读取过慢时丢弃多余的事件
+const observerBuffer = 64
+
+// subscribe 订阅服务的状态变化，使用完毕后必须调用unsubscribe。
+func subscribe(service string) chan unitEvent {
+	observerLock.Lock()
+	defer observerLock.Unlock()
+	ch := make(chan unitEvent, observerBuffer)
+	observers[ch] = service
+	return ch
+}
+
+// unsubscribe 取消订阅。
+func unsubscribe(ch chan unitEvent) {
+	observerLock.Lock()
+	defer observerLock.Unlock()
+	delete(observers, ch)
+}
+
+// emit 将服务的状态变化通知给所有订阅了该服务的观察者，不会阻塞。
+func emit(service, event, detail string) {
+	e := unitEvent{time: time.Now(), service: service, event: event, detail: detail}
+	observerLock.Lock()
+	defer observerLock.Unlock()
+	for ch, s := range observers {
+		if s != service {
+			continue
+		}
+		select {
+		case ch <- e:
+		default:
+			log.Printf("Dropping event for slow observer of %s\n", service)
+		}
+	}
+}
+
+// Follow 先写回服务当前的ActiveState，之后将服务的每次状态变化作为一帧推送给客户端，
+// 直到客户端关闭连接。
+func Follow(conn net.Conn, service string) {
+	ch := subscribe(service)
+	defer unsubscribe(ch)
+
+	lock.Lock()
+	opts, _ := loadUnit(service)
+	active, sub := unitActiveState(service, opts)
+	lock.Unlock()
+	current := fmt.Sprintf("%s %s.service: %s (%s)", formatTimestamp(time.Now()), service, active, sub)
+	if err := writeFrame(conn, encodeResponse(current, nil)); err != nil {
+		return
+	}
+
+	// 客户端只接收不发送，读到EOF说明它已断开
+	closed := make(chan struct{})
+	go func() {
+		_, _ = readFrame(conn)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-ch:
+			if err := writeFrame(conn, encodeResponse(e.String(), nil)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// follow 是客户端的status --follow：打印服务当前状态及之后的每次状态变化，直到守护进程断开连接。
+func follow(service string) error {
+	conn, err := dial()
+	if err != nil {
+		return fmt.Errorf("Error connecting to daemon: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	msg := "follow:" + service
+	if token := clientToken(); token != "" {
+		msg = token + ":" + msg
+	}
+	if err = writeFrame(conn, []byte(msg)); err != nil {
+		return fmt.Errorf("Error sending message: %v", err)
+	}
+	for {
+		frame, err2 := readFrame(conn)
+		if err2 != nil {
+			return nil
+		}
+		line, err2 := decodeResponse(frame)
+		if err2 != nil {
+			return err2
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// execContext 描述服务所有Exec*命令共享的执行环境。
+// 按照systemd的约定，ExecStartPre、ExecStart、ExecStartPost和ExecStop
+// 使用相同的工作目录、环境变量和运行用户，而不是继承守护进程的设置。
+type execContext struct {
+	// dir 是命令的工作目录
+	dir string
+	// env 是命令的完整环境变量列表
+	env []string
+	// credential 是User=/Group=对应的运行身份，为nil时使用守护进程的身份
+	credential *syscall.Credential
+}
+
+// newExecContext 根据单元选项解析服务的执行环境。
+// 环境变量依次由守护进程环境、管理器环境、EnvironmentFile=和Environment=组成。调用方必须持有lock。
+func newExecContext(opts []*unit.UnitOption) (*execContext, error) {
+	ctx := &execContext{
+		dir: workingDirectory(opts),
+		env: append(os.Environ(), managerEnviron()...),
+	}
+
+	for _, file := range getAllOptions(opts, "Service", "EnvironmentFile") {
+		// 以-开头的文件不存在时忽略
+		optional := strings.HasPrefix(file, "-")
+		vars, err := readEnvironmentFile(strings.TrimPrefix(file, "-"))
+		if err != nil {
+			if optional && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		ctx.env = append(ctx.env, vars...)
+	}
+	for _, val := range getAllOptions(opts, "Service", "Environment") {
+		for _, kv := range splitQuoted(val) {
+			if strings.Contains(kv, "=") {
+				ctx.env = append(ctx.env, kv)
+			}
+		}
+	}
+
+	userName, _ := getOptions(opts, "Service", "User")
+	groupName, _ := getOptions(opts, "Service", "Group")
+	if userName != "" || groupName != "" {
+		credential, err := lookupCredential(userName, groupName)
+		if err != nil {
+			return nil, err
+		}
+		ctx.credential = credential
+	}
+	return ctx, nil
+}
+
+// getenv 在执行环境中查找环境变量，后出现的值优先。
+func (c *execContext) getenv(key string) string {
+	for i := len(c.env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(c.env[i], "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// execPrefix 是Exec*指令值开头的特殊前缀。
+type execPrefix struct {
+	// ignoreFailure 对应"-"，命令以非0退出码退出时不视为失败
+	ignoreFailure bool
+	// argv0 对应"@"，第二个参数作为argv[0]传给进程
+	argv0 bool
+	// privileged 对应"+"，忽略User=/Group=，以守护进程的完整权限运行
+	privileged bool
+	// noExpand 对应":"，不进行环境变量替换
+	noExpand bool
+}
+
+// splitExecPrefix 解析并去掉Exec*指令值开头的前缀。
+// "!"和"!!"依赖systemd的权限模型，这里不支持，会被去掉并记录警告，命令仍按User=/Group=运行。
+func splitExecPrefix(val string) (execPrefix, string) {
+	var prefix execPrefix
+	for {
+		switch {
+		case strings.HasPrefix(val, "-"):
+			prefix.ignoreFailure = true
+		case strings.HasPrefix(val, "@"):
+			prefix.argv0 = true
+		case strings.HasPrefix(val, "+"):
+			prefix.privileged = true
+		case strings.HasPrefix(val, ":"):
+			prefix.noExpand = true
+		case strings.HasPrefix(val, "!"):
+			log.Printf("Exec prefix \"!\" is not supported, ignoring it: %s\n", val)
+		default:
+			return prefix, val
+		}
+		val = val[1:]
+	}
+}
+
+// command 基于Exec*指令的值构建一个使用该执行环境的命令，支持-、@、+、:前缀。
+// extraEnv 会追加到环境变量之后（如MAINPID），并参与$变量替换。
+func (c *execContext) command(val string, extraEnv ...string) (*exec.Cmd, error) {
+	ctx := *c
+	ctx.env = append(append([]string{}, c.env...), extraEnv...)
+	prefix, val := splitExecPrefix(val)
+	getenv := ctx.getenv
+	if prefix.noExpand {
+		getenv = nil
+	}
+	name, args, err := parseCommand(val, getenv)
+	if err != nil {
+		return nil, err
+	}
+	path, err := lookExecutable(name, ctx.getenv("PATH"))
+	if err != nil {
+		return nil, err
+	}
+	argv0 := name
+	if prefix.argv0 {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("missing argv[0] after @ prefix: %s", val)
+		}
+		argv0, args = args[0], args[1:]
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Args[0] = argv0
+	cmd.Dir = ctx.dir
+	cmd.Env = ctx.env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if !prefix.privileged {
+		cmd.SysProcAttr.Credential = ctx.credential
+	}
+	return cmd, nil
+}
+
+// defaultSearchPath 是执行环境未设置PATH时查找可执行文件的路径，与systemd一致
+const defaultSearchPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// lookExecutable 解析命令的可执行文件并确认其存在且可执行，返回其绝对路径。
+// 绝对路径直接检查；不含"/"的名称在searchPath中查找，searchPath为空时使用defaultSearchPath；
+// 其余相对路径与systemd一样不被接受。在启动前检查可以向客户端报告明确的错误，而不是笼统的exec失败。
+func lookExecutable(name, searchPath string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, checkExecutable(name)
+	}
+	if strings.Contains(name, "/") {
+		return "", fmt.Errorf("executable path %s must be absolute", name)
+	}
+	if searchPath == "" {
+		searchPath = defaultSearchPath
+	}
+	for _, dir := range filepath.SplitList(searchPath) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if checkExecutable(path) == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("executable %s not found in %s", name, searchPath)
+}
+
+// checkExecutable 检查路径是否为可执行的普通文件。
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("executable %s not found", path)
+	}
+	if err != nil {
+		return fmt.Errorf("executable %s cannot be accessed: %w", path, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return fmt.Errorf("%s is not an executable file", path)
+	}
+	return nil
+}
+
+// runControl 同步执行一组控制命令（如ExecStartPre、ExecStop），遇到第一个失败即返回。
+// 带"-"前缀的命令以非0退出码退出时只记录日志。调用方必须持有服务的锁和lock，等待命令退出期间释放lock。
+func (c *execContext) runControl(service, directive string, vals []string, extraEnv ...string) error {
+	for _, val := range vals {
+		cmd, err := c.command(val, extraEnv...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", directive, err)
+		}
+		log.Printf("Running %s for %s: %s\n", directive, service, cmd.String())
+		var out bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &out, &out
+		if err = startWaited(cmd); err == nil {
+			unlocked(func() { err = cmd.Wait() })
+			doneWaiting(cmd)
+		}
+		if err == nil {
+			continue
+		}
+		var exitErr *exec.ExitError
+		if prefix, _ := splitExecPrefix(val); prefix.ignoreFailure && errors.As(err, &exitErr) {
+			log.Printf("Ignoring failure of %s for %s: %v\n", directive, service, err)
+			continue
+		}
+		return fmt.Errorf("%s failed: %v: %s", directive, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// readEnvironmentFile 读取EnvironmentFile=指定的文件，返回其中的KEY=VALUE列表。
+// 空行和以#或;开头的注释行会被忽略。
+func readEnvironmentFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var vars []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		vars = append(vars, strings.TrimSpace(key)+"="+unquoteEnvValue(strings.TrimSpace(value)))
+	}
+	return vars, scanner.Err()
+}
+
+// unquoteEnvValue 去掉环境变量文件中值两端成对的引号。与shell一致，单引号内的内容按字面保留，
+// 双引号内的\"、\\、\`和\$被解码，其余反斜杠原样保留。两端不是同一种引号时原样返回。
+func unquoteEnvValue(value string) string {
+	if len(value) < 2 || value[0] != value[len(value)-1] {
+		return value
+	}
+	switch value[0] {
+	case '\'':
+		return value[1 : len(value)-1]
+	case '"':
+		inner := value[1 : len(value)-1]
+		var b strings.Builder
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' {
+				if i+1 == len(inner) {
+					// 结尾的引号被转义，双引号没有闭合
+					return value
+				}
+				if strings.IndexByte("\"\\`$", inner[i+1]) >= 0 {
+					i++
+				}
+			}
+			b.WriteByte(inner[i])
+		}
+		return b.String()
+	}
+	return value
+}
+
+// lookupCredential 将User=和Group=解析为进程凭据。
+// 只指定Group=时以当前用户身份运行，只指定User=时使用该用户的主组。
+func lookupCredential(userName, groupName string) (*syscall.Credential, error) {
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid User %s: %w", userName, err)
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		credential.Uid = uint32(uid)
+		credential.Gid = uint32(gid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Group %s: %w", groupName, err)
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		credential.Gid = uint32(gid)
+	}
+	return credential, nil
+}
+
+// word 是命令行拆分出的一个参数。quoted表示参数中包含引号或转义，
+// 这样的参数不会再按$VAR规则拆分。
+type word struct {
+	text   string
+	quoted bool
+}
+
+// splitWords 按systemd的规则将命令行拆分为参数：以空白分隔，单引号或双引号包裹的部分作为一个整体，
+// 反斜杠转义（如\"、\\、\n、\t、\xHH）会被解码。引号未闭合时返回错误。
+func splitWords(s string) ([]word, error) {
+	var words []word
+	var current strings.Builder
+	var quote rune
+	inWord, quoted := false, false
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			n, decoded := unescape(runes[i+1:])
+			current.WriteString(decoded)
+			i += n
+			inWord, quoted = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord, quoted = true, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word{current.String(), quoted})
+				current.Reset()
+				inWord, quoted = false, false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word{current.String(), quoted})
+	}
+	return words, nil
+}
+
+// escapes 是支持的单字符转义序列
+var escapes = map[rune]string{
+	'a': "\a", 'b': "\b", 'f': "\f", 'n': "\n", 'r': "\r", 't': "\t", 'v': "\v",
+	's': " ", '\\': "\\", '"': "\"", '\'': "'",
+}
+
+// unescape 解码反斜杠之后的转义序列，返回消耗的字符数和解码结果。
+// 无法识别的转义按字面保留该字符。
+func unescape(runes []rune) (int, string) {
+	if decoded, ok := escapes[runes[0]]; ok {
+		return 1, decoded
+	}
+	if runes[0] == 'x' && len(runes) >= 3 {
+		if b, err := strconv.ParseUint(string(runes[1:3]), 16, 8); err == nil {
+			return 3, string([]byte{byte(b)})
+		}
+	}
+	if runes[0] >= '0' && runes[0] <= '7' && len(runes) >= 3 {
+		if b, err := strconv.ParseUint(string(runes[:3]), 8, 8); err == nil {
+			return 3, string([]byte{byte(b)})
+		}
+	}
+	return 1, string(runes[0])
+}
+
+// splitQuoted 按空白拆分字符串，引号和转义按splitWords的规则处理，格式错误时返回nil。
+func splitQuoted(s string) []string {
+	words, err := splitWords(s)
+	if err != nil {
+		return nil
+	}
+	fields := make([]string, 0, len(words))
+	for _, w := range words {
+		fields = append(fields, w.text)
+	}
+	return fields
+}
+
+// envReference 匹配${VAR}形式的变量引用，以及表示字面"$"的"$$"
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// bareEnvReference 匹配单独作为参数的$VAR
+var bareEnvReference = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)$`)
+
+// parseCommand 将Exec*指令的值拆分为可执行文件和参数列表。
+// 与systemd一致，单独作为参数的$VAR会被替换为变量值并按空白拆分为多个参数（为空时忽略），
+// ${VAR}则原样替换到所在参数中，"$$"替换为"$"。getenv为nil时不进行变量替换。
+// 命令不经过shell执行，引号内的内容（如sh -c "a && b"中的脚本）作为一个参数传递，
+// 管道、重定向等只有显式调用shell时才有效，shell自身的变量需要写成$${VAR}或$$VAR。
+func parseCommand(val string, getenv func(string) string) (string, []string, error) {
+	words, err := splitWords(val)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(words) == 0 {
+		return "", nil, errors.New("empty command line")
+	}
+	var argv []string
+	for _, w := range words {
+		if getenv == nil {
+			argv = append(argv, w.text)
+			continue
+		}
+		if m := bareEnvReference.FindStringSubmatch(w.text); m != nil && !w.quoted {
+			argv = append(argv, strings.Fields(getenv(m[1]))...)
+			continue
+		}
+		argv = append(argv, envReference.ReplaceAllStringFunc(w.text, func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			return getenv(ref[2 : len(ref)-1])
+		}))
+	}
+	if len(argv) == 0 {
+		return "", nil, errors.New("empty command line")
+	}
+	return argv[0], argv[1:], nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{`n`, 1, "\n"},
+		{`t`, 1, "\t"},
+		{`s`, 1, " "},
+		{`\`, 1, `\`},
+		{`"`, 1, `"`},
+		{`'`, 1, `'`},
+		{`x41`, 3, "A"},
+		{`x7f`, 3, "\x7f"},
+		{`xff`, 3, "\xff"},
+		{`x4`, 1, "x"},
+		{`xzz`, 1, "x"},
+		{`101`, 3, "A"},
+		{`377`, 3, "\xff"},
+		{`400`, 1, "4"},
+		{`q`, 1, "q"},
+	}
+	for _, tt := range tests {
+		n, got := unescape([]rune(tt.in))
+		if n != tt.n || got != tt.want {
+			t.Errorf("unescape(%q) = %d, %q; want %d, %q", tt.in, n, got, tt.n, tt.want)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	env := map[string]string{"ARGS": "-a  -b", "NAME": "world", "EMPTY": ""}
+	getenv := func(key string) string { return env[key] }
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`/bin/echo "a b" 'c d'`, []string{"/bin/echo", "a b", "c d"}},
+		{`/bin/echo a\sb`, []string{"/bin/echo", "a b"}},
+		{`/bin/echo \xc3\xa9 \303\251`, []string{"/bin/echo", "é", "é"}},
+		{`/bin/echo $ARGS`, []string{"/bin/echo", "-a", "-b"}},
+		{`/bin/echo "${ARGS}"`, []string{"/bin/echo", "-a  -b"}},
+		{`/bin/echo $EMPTY x`, []string{"/bin/echo", "x"}},
+		{`/bin/echo hello-${NAME}`, []string{"/bin/echo", "hello-world"}},
+		{`/bin/sh -c "echo $$HOME && echo $${NAME}"`, []string{"/bin/sh", "-c", "echo $HOME && echo ${NAME}"}},
+	}
+	for _, tt := range tests {
+		path, args, err := parseCommand(tt.in, getenv)
+		if err != nil {
+			t.Errorf("parseCommand(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got := append([]string{path}, args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCommand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{``, `   `, `/bin/echo "a`, `/bin/echo a\`, `$EMPTY`} {
+		if _, _, err := parseCommand(in, getenv); err == nil {
+			t.Errorf("parseCommand(%q) succeeded, want error", in)
+		}
+	}
+}
+
+// TestShellCompoundCommand 通过sh -c运行包含&&、管道和重定向的组合命令，
+// ${VAR}由systemctl替换，$$VAR留给shell展开。
+func TestShellCompoundCommand(t *testing.T) {
+	dir := setupTestPaths(t)
+	out := filepath.Join(dir, "out")
+	writeUnit(t, "compound.service", "[Service]\nType=oneshot\nEnvironment=OUT="+out+" GREETING=hello\n"+
+		`ExecStart=/bin/sh -c "echo ${GREETING} > ${OUT} && echo $$GREETING world | tr a-z A-Z >> $${OUT}"`+"\n")
+	if err := Start("compound"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "hello\nHELLO WORLD\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+// TestControlCommandsShareContext 检查ExecStartPre和ExecStop与ExecStart使用相同的工作目录和环境变量。
+func TestControlCommandsShareContext(t *testing.T) {
+	dir := setupTestPaths(t)
+	work := filepath.Join(dir, "work")
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 在当前目录下写入以参数命名的文件，记录工作目录和GREETING
+	record := filepath.Join(dir, "record.sh")
+	if err := os.WriteFile(record, []byte("#!/bin/sh\npwd > \"$1\"\nprintenv GREETING >> \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "context.service", "[Service]\nWorkingDirectory="+work+"\nEnvironment=GREETING=hello\n"+
+		"ExecStartPre="+record+" pre\nExecStart=/bin/sleep 60\nExecStop="+record+" stop\n")
+	if err := Start("context"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Stop("context"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pre", "stop"} {
+		data, err := os.ReadFile(filepath.Join(work, name))
+		if err != nil {
+			t.Fatalf("%s command did not run in WorkingDirectory: %v", name, err)
+		}
+		if got, want := string(data), work+"\nhello\n"; got != want {
+			t.Errorf("%s command output = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReadEnvironmentFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	content := `# comment
+; comment
+PLAIN=value
+SPACED = padded  
+DOUBLE="two words"
+SINGLE='it''s'
+LITERAL='a \"b\" $HOME'
+ESCAPED="say \"hi\" \\ \$HOME \n"
+MIXED="open'
+LEADING="unterminated
+TRAILING=value"
+INNER=a"b"c
+ESCAPEDCLOSE="abc\"
+EMPTY=""
+QUOTE="
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readEnvironmentFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"PLAIN=value",
+		"SPACED=padded",
+		"DOUBLE=two words",
+		"SINGLE=it''s",
+		`LITERAL=a \"b\" $HOME`,
+		`ESCAPED=say "hi" \ $HOME \n`,
+		`MIXED="open'`,
+		`LEADING="unterminated`,
+		`TRAILING=value"`,
+		`INNER=a"b"c`,
+		`ESCAPEDCLOSE="abc\"`,
+		"EMPTY=",
+		`QUOTE="`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readEnvironmentFile() = %q, want %q", got, want)
+	}
+}
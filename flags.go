@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pathOption 是一个可通过命令行选项和环境变量覆盖的路径、地址等全局配置。
+type pathOption struct {
+	flag string
+	env  string
+	dst  *string
+}
+
+// pathOptions 列出所有全局配置，未覆盖时使用遵循systemd约定的默认值
+var pathOptions = []pathOption{
+	{"--socket", "SYSTEMCTL_SOCKET", &socketPath},
+	{"--listen-tcp", "SYSTEMCTL_LISTEN_TCP", &tcpAddress},
+	{"--host", "SYSTEMCTL_HOST", &remoteAddress},
+	{"--unit-path", "SYSTEMCTL_UNIT_PATH", &localUnitPath},
+	{"--system-unit-path", "SYSTEMCTL_SYSTEM_UNIT_PATH", &vendorUnitPath},
+	{"--unit-search-path", "SYSTEMCTL_UNIT_SEARCH_PATH", &unitSearchPath},
+	{"--enable-path", "SYSTEMCTL_ENABLE_PATH", &enablePath},
+	{"--log-path", "SYSTEMCTL_LOG_PATH", &logPath},
+	{"--runtime-path", "SYSTEMCTL_RUNTIME_PATH", &runtimePath},
+	{"--state-path", "SYSTEMCTL_STATE_PATH", &statePath},
+	{"--token-path", "SYSTEMCTL_TOKEN_PATH", &tokenPath},
+	{"--boot-concurrency", "SYSTEMCTL_BOOT_CONCURRENCY", &bootJobs},
+}
+
+// parseGlobalFlags 解析命令之前的全局选项并返回去掉这些选项后的参数列表。
+// 每个路径先取对应环境变量的值，再由命令行选项（--opt <path>或--opt=<path>）覆盖，
+// 便于以非特权用户运行守护进程、运行多个独立的守护进程或在测试中使用临时目录。
+func parseGlobalFlags(args []string) ([]string, error) {
+	for _, opt := range pathOptions {
+		if val := os.Getenv(opt.env); val != "" {
+			*opt.dst = val
+		}
+	}
+
+	rest := []string{args[0]}
+	i := 1
+next:
+	for ; i < len(args); i++ {
+		arg := args[i]
+		for _, opt := range pathOptions {
+			switch {
+			case arg == opt.flag:
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("option %s requires a value", arg)
+				}
+				*opt.dst = args[i+1]
+				i++
+				continue next
+			case strings.HasPrefix(arg, opt.flag+"="):
+				*opt.dst = strings.TrimPrefix(arg, opt.flag+"=")
+				continue next
+			}
+		}
+		break
+	}
+	return append(rest, args[i:]...), nil
+}
+
+// globalFlagArgs 以命令行选项的形式返回当前所有路径配置，用于daemon-reexec时传给新进程。
+func globalFlagArgs() []string {
+	var args []string
+	for _, opt := range pathOptions {
+		args = append(args, opt.flag, *opt.dst)
+	}
+	return args
+}
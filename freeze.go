@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os/exec"
+	"syscall"
+)
+
+// Freeze 向服务的进程组发送SIGSTOP挂起它，进程的内存和打开的文件保持不变，Thaw后继续运行。
+// 只有配置了MemoryMax=等资源限制的服务才有独立的cgroup，其余服务无法使用cgroup freezer，
+// 为使所有服务行为一致，统一使用SIGSTOP实现。
+func Freeze(service string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	command := mapCommand[service]
+	if !isCommandRunning(service, command) {
+		return errors.New("service is not run")
+	}
+	state := getState(service)
+	if state.frozen {
+		return nil
+	}
+	pid := mainPID(service, command)
+	if err := signalProcess(pid, syscall.SIGSTOP, true); err != nil {
+		return err
+	}
+	state.frozen = true
+	log.Printf("Froze service %s (PID: %d)\n", service, pid)
+	return nil
+}
+
+// Thaw 向已冻结服务的进程组发送SIGCONT恢复运行。
+func Thaw(service string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	command := mapCommand[service]
+	if !isCommandRunning(service, command) {
+		return errors.New("service is not run")
+	}
+	state := getState(service)
+	if !state.frozen {
+		return nil
+	}
+	if err := thaw(service, command); err != nil {
+		return err
+	}
+	log.Printf("Thawed service %s\n", service)
+	return nil
+}
+
+// thaw 恢复冻结的服务实例，调用方必须持有lock。
+func thaw(service string, command *exec.Cmd) error {
+	if err := signalProcess(mainPID(service, command), syscall.SIGCONT, true); err != nil {
+		return err
+	}
+	getState(service).frozen = false
+	return nil
+}
+
+// isFrozen 判断服务是否已被Freeze挂起，调用方必须持有lock。
+func isFrozen(service string) bool {
+	state := mapState[service]
+	return state != nil && state.frozen
+}
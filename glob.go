@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// globOps 是服务名称可以使用通配符模式（如worker-*）的操作
+var globOps = map[string]bool{
+	"start": true, "stop": true, "restart": true, "try-restart": true,
+	"reload": true, "reload-or-restart": true, "kill": true, "freeze": true,
+	"thaw": true, "reset-failed": true, "enable": true, "disable": true,
+}
+
+// isGlob 判断服务名称是否为通配符模式。
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// expandGlob 返回名称与模式匹配的服务：包括守护进程跟踪的服务和各单元目录中的单元文件，按名称排序。
+// 模式中必须包含通配符以外的字符，"*"这类匹配所有服务的模式会被拒绝，避免误操作全部服务。
+func expandGlob(pattern string) ([]string, error) {
+	if strings.Trim(pattern, "*?") == "" {
+		return nil, fmt.Errorf("pattern %q would match every unit, name the units explicitly", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	seen := map[string]bool{}
+	lock.Lock()
+	for service := range mapCommand {
+		seen[service] = true
+	}
+	lock.Unlock()
+	for _, dir := range unitDirs() {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.service"))
+		for _, file := range files {
+			// 模板单元（x@.service）需要实例名，不参与匹配
+			if name := strings.TrimSuffix(filepath.Base(file), ".service"); !strings.HasSuffix(name, "@") {
+				seen[name] = true
+			}
+		}
+	}
+
+	var services []string
+	for service := range seen {
+		if ok, _ := path.Match(pattern, service); ok {
+			services = append(services, service)
+		}
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no units matched pattern %q", pattern)
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// dispatchGlob 对与模式匹配的每个服务依次执行操作，并返回每个服务的结果。
+// 与批量请求一致，任一服务失败时以错误的形式返回全部结果。
+func dispatchGlob(op, pattern string, args []string) (string, error) {
+	services, err := expandGlob(pattern)
+	if err != nil {
+		return "", err
+	}
+	var results []string
+	failed := false
+	for _, service := range services {
+		res, err2 := dispatch(op, service, args)
+		if err2 != nil {
+			failed = true
+			results = append(results, fmt.Sprintf("%s %s: %v", op, service, err2))
+			continue
+		}
+		results = append(results, fmt.Sprintf("%s %s: %s", op, service, res))
+	}
+	if failed {
+		return "", errors.New(strings.Join(results, "\n"))
+	}
+	return strings.Join(results, "\n"), nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+const (
+	// defaultHealthCheckInterval 是X-HealthCheckIntervalSec=的默认值
+	defaultHealthCheckInterval = 30 * time.Second
+	// defaultHealthCheckRetries 是X-HealthCheckRetries=的默认值
+	defaultHealthCheckRetries = 3
+)
+
+// healthCheck 是服务的健康检查配置。
+type healthCheck struct {
+	// check 是X-HealthCheck=，http://或https://开头时为URL，否则为与ExecStart=格式相同的命令
+	check string
+	// interval 是X-HealthCheckIntervalSec=，两次检查之间的间隔，也是单次检查的超时
+	interval time.Duration
+	// retries 是X-HealthCheckRetries=，连续失败这么多次后服务被视为unhealthy
+	retries int
+	// restart 是X-HealthCheckRestart=，为true时服务变为unhealthy后重启它
+	restart bool
+}
+
+// parseHealthCheck 读取单元的健康检查配置，未设置X-HealthCheck=时返回nil。
+func parseHealthCheck(service string, opts []*unit.UnitOption) *healthCheck {
+	check, _ := getOptions(opts, "Service", "X-HealthCheck")
+	if check == "" {
+		return nil
+	}
+	hc := &healthCheck{check: check, interval: defaultHealthCheckInterval, retries: defaultHealthCheckRetries}
+	if val, _ := getOptions(opts, "Service", "X-HealthCheckIntervalSec"); val != "" {
+		if d, err := parseTimeSpan(val); err != nil || d <= 0 || d == timeSpanInfinity {
+			log.Printf("Invalid X-HealthCheckIntervalSec for %s: %s\n", service, val)
+		} else {
+			hc.interval = d
+		}
+	}
+	if val, _ := getOptions(opts, "Service", "X-HealthCheckRetries"); val != "" {
+		if n, err := strconv.Atoi(val); err != nil || n <= 0 {
+			log.Printf("Invalid X-HealthCheckRetries for %s: %s\n", service, val)
+		} else {
+			hc.retries = n
+		}
+	}
+	val, _ := getOptions(opts, "Service", "X-HealthCheckRestart")
+	switch val {
+	case "yes", "true", "on", "1":
+		hc.restart = true
+	}
+	return hc
+}
+
+// monitorHealth 每隔interval对服务实例运行一次健康检查并更新其健康状态，实例退出或被替换后返回。
+// 连续失败retries次后服务被视为unhealthy，设置了X-HealthCheckRestart=yes时重启服务。
+func (hc *healthCheck) monitorHealth(service string, command *exec.Cmd, execCtx *execContext, exited <-chan struct{}) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+		}
+
+		lock.Lock()
+		if mapCommand[service] != command || isFrozen(service) {
+			current := mapCommand[service] == command
+			lock.Unlock()
+			if current {
+				continue
+			}
+			return
+		}
+		env := "MAINPID=" + strconv.Itoa(mainPID(service, command))
+		lock.Unlock()
+
+		err := hc.run(execCtx, env)
+		if err == nil {
+			failures = 0
+		} else {
+			failures++
+			log.Printf("Health check of %s failed (%d/%d): %v\n", service, failures, hc.retries, err)
+		}
+
+		unlock := lockService(service)
+		if mapCommand[service] != command {
+			unlock()
+			return
+		}
+		state := getState(service)
+		previous := state.health
+		switch {
+		case err == nil:
+			state.health = "healthy"
+		case failures >= hc.retries:
+			state.health = "unhealthy"
+		}
+		if state.health != previous {
+			emit(service, state.health, "")
+		}
+		if state.health == "unhealthy" && hc.restart {
+			log.Printf("Restarting unhealthy service %s\n", service)
+			if err = restart(service); err != nil {
+				log.Printf("Failed to restart service: %v\n", err)
+			}
+			unlock()
+			return
+		}
+		unlock()
+	}
+}
+
+// run 执行一次健康检查。URL检查要求响应状态码为2xx或3xx，命令检查要求以0退出，均需在interval内完成。
+func (hc *healthCheck) run(execCtx *execContext, env string) error {
+	if strings.HasPrefix(hc.check, "http://") || strings.HasPrefix(hc.check, "https://") {
+		client := http.Client{Timeout: hc.interval}
+		resp, err := client.Get(hc.check)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP status %s", resp.Status)
+		}
+		return nil
+	}
+
+	cmd, err := execCtx.command(hc.check, env)
+	if err != nil {
+		return err
+	}
+	if err = startWaited(cmd); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+		doneWaiting(cmd)
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(hc.interval):
+		_ = signalProcess(cmd.Process.Pid, syscall.SIGKILL, true)
+		<-done
+		return fmt.Errorf("timed out after %v", hc.interval)
+	}
+}
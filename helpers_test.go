@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupTestPaths 将单元、启用、日志、运行时、状态和cgroup路径指向临时目录，返回该目录。
+// 测试结束时停止测试中启动的服务并恢复全局状态。
+func setupTestPaths(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved := []*string{&localUnitPath, &vendorUnitPath, &unitSearchPath, &enablePath, &logPath,
+		&runtimePath, &statePath, &socketPath, &tokenPath, &cgroupRoot}
+	values := make([]string, len(saved))
+	for i, p := range saved {
+		values[i] = *p
+	}
+
+	localUnitPath = filepath.Join(dir, "etc")
+	vendorUnitPath = filepath.Join(dir, "lib")
+	unitSearchPath = ""
+	enablePath = filepath.Join(localUnitPath, defaultTarget+".wants")
+	logPath = filepath.Join(dir, "log")
+	runtimePath = filepath.Join(dir, "run")
+	statePath = filepath.Join(dir, "state.json")
+	socketPath = filepath.Join(dir, "sock")
+	tokenPath = filepath.Join(dir, "token")
+	// 不存在的cgroup根目录，测试不会修改主机的cgroup
+	cgroupRoot = filepath.Join(dir, "cgroup")
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	for _, d := range []string{localUnitPath, vendorUnitPath, enablePath} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		lock.Lock()
+		var running []string
+		for service := range mapCommand {
+			running = append(running, service)
+		}
+		lock.Unlock()
+		for _, service := range running {
+			_ = Stop(service)
+		}
+		lock.Lock()
+		mapCommand = map[string]*exec.Cmd{}
+		mapState = map[string]*serviceState{}
+		managerEnv = map[string]string{}
+		lock.Unlock()
+		for i, p := range saved {
+			*p = values[i]
+		}
+		log.SetOutput(os.Stderr)
+	})
+	return dir
+}
+
+// writeUnit 在本地单元目录中写入单元文件。
+func writeUnit(t testing.TB, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(localUnitPath, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// enableForTest 将服务链接到默认目标的.wants目录。
+func enableForTest(t *testing.T, service string) {
+	t.Helper()
+	if err := os.Symlink(filepath.Join(localUnitPath, service+".service"), filepath.Join(enablePath, service+".service")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor 轮询cond直到其为真，超过timeout时测试失败。
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// isRunning 在持有lock的情况下判断服务是否正在运行。
+func isRunning(service string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return isCommandRunning(service, mapCommand[service])
+}
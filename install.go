@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// defaultTarget 是enablePath对应的目标
+const defaultTarget = "multi-user.target"
+
+// wantsDir 返回目标的.wants目录。multi-user.target即enablePath，其余目标与它位于同一父目录下。
+func wantsDir(target string) string {
+	if target == defaultTarget {
+		return enablePath
+	}
+	return filepath.Join(filepath.Dir(enablePath), target+".wants")
+}
+
+// wantedBy 返回[Install]中WantedBy=列出的所有目标，每个值可包含多个以空格分隔的目标。
+func wantedBy(opts []*unit.UnitOption) []string {
+	var targets []string
+	for _, val := range getAllOptions(opts, "Install", "WantedBy") {
+		targets = append(targets, strings.Fields(val)...)
+	}
+	return targets
+}
+
+// hasInstallSection 判断单元是否包含[Install]节。
+func hasInstallSection(opts []*unit.UnitOption) bool {
+	for _, opt := range opts {
+		if opt.Section == "Install" {
+			return true
+		}
+	}
+	return false
+}
+
+// installTargets 返回启用服务时需要链接到的目标。没有[Install]节的单元与systemd一致不可启用，
+// force为true时仍链接到默认目标；有[Install]节但没有WantedBy=时同样使用默认目标。
+func installTargets(service string, opts []*unit.UnitOption, force bool) ([]string, error) {
+	if !hasInstallSection(opts) {
+		if !force {
+			return nil, fmt.Errorf("unit %s.service has no [Install] section and is not enablable, use --force to enable it anyway", service)
+		}
+		return []string{defaultTarget}, nil
+	}
+	targets := wantedBy(opts)
+	if len(targets) == 0 {
+		targets = []string{defaultTarget}
+	}
+	for _, target := range targets {
+		if !strings.HasSuffix(target, ".target") || strings.Contains(target, "/") {
+			return nil, fmt.Errorf("invalid WantedBy= target %q in %s.service", target, service)
+		}
+	}
+	return targets, nil
+}
+
+// linkService 在每个目标的.wants目录中创建指向单元文件的符号链接，目录不存在时创建。
+func linkService(service, path string, targets []string) error {
+	for _, target := range targets {
+		dir := wantsDir(target)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(path, filepath.Join(dir, service+".service")); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// errNotEnabled 表示disable的服务没有被启用
+var errNotEnabled = errors.New("service is not enabled")
+
+// unlinkService 从默认目标和WantedBy=中各目标的.wants目录中删除服务的符号链接。
+func unlinkService(service string, opts []*unit.UnitOption) error {
+	removed := false
+	for _, target := range append([]string{defaultTarget}, wantedBy(opts)...) {
+		err := os.Remove(filepath.Join(wantsDir(target), service+".service"))
+		if err == nil {
+			removed = true
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if !removed {
+		return errNotEnabled
+	}
+	return nil
+}
+
+// enableUnit 是Enable的实现，seen记录本次已处理的单元，防止Also=相互引用时无限递归。调用方必须持有lock。
+func enableUnit(name string, force bool, seen map[string]bool) error {
+	if seen[name] {
+		return nil
+	}
+	seen[name] = true
+
+	var path string
+	var opts []*unit.UnitOption
+	var err error
+	if isOtherUnit(name) {
+		if path = findUnitFile(name); path == "" {
+			return errors.New("no unit found")
+		}
+		if opts, err = loadUnitFile(path); err != nil {
+			return err
+		}
+		if err = os.Symlink(path, filepath.Join(enablePath, name)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	} else {
+		if isMasked(name) {
+			return errMasked(name)
+		}
+		if path = find(name); path == "" {
+			return errors.New("no service found")
+		}
+		if opts, err = loadUnit(name); err != nil {
+			return err
+		}
+		targets, err2 := installTargets(name, opts, force)
+		if err2 != nil {
+			return err2
+		}
+		if err = linkService(name, path, targets); err != nil {
+			return err
+		}
+	}
+
+	if err = linkAliases(name, path, opts); err != nil {
+		return err
+	}
+	for _, also := range alsoUnits(opts) {
+		if err = enableUnit(also, force, seen); err != nil {
+			return fmt.Errorf("failed to enable %s listed in Also=: %w", also, err)
+		}
+	}
+	return nil
+}
+
+// disableUnit 是Disable的实现，Also=列出的单元未启用时不视为错误。调用方必须持有lock。
+func disableUnit(name string, seen map[string]bool) error {
+	if seen[name] {
+		return nil
+	}
+	seen[name] = true
+
+	var opts []*unit.UnitOption
+	var err error
+	if isOtherUnit(name) {
+		if path := findUnitFile(name); path != "" {
+			opts, _ = loadUnitFile(path)
+		}
+		err = os.Remove(filepath.Join(enablePath, name))
+	} else {
+		opts, _ = loadUnit(name)
+		err = unlinkService(name, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	unlinkAliases(opts)
+	for _, also := range alsoUnits(opts) {
+		if err = disableUnit(also, seen); err != nil && !os.IsNotExist(err) && !errors.Is(err, errNotEnabled) {
+			return fmt.Errorf("failed to disable %s listed in Also=: %w", also, err)
+		}
+	}
+	return nil
+}
+
+// isOtherUnit 判断名称是否表示socket或timer单元，服务名称不带后缀。
+func isOtherUnit(name string) bool {
+	return strings.HasSuffix(name, ".socket") || strings.HasSuffix(name, ".timer")
+}
+
+// unitFileName 返回单元的文件名，服务名称补上.service后缀。
+func unitFileName(name string) string {
+	if isOtherUnit(name) {
+		return name
+	}
+	return name + ".service"
+}
+
+// loadUnitFile 读取并解析socket或timer等没有drop-in的单元文件。
+func loadUnitFile(path string) ([]*unit.UnitOption, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSystemdService(string(content))
+}
+
+// alsoUnits 返回[Install]中Also=列出的单元，每个值可包含多个以空格分隔的单元，服务名称去掉.service后缀。
+func alsoUnits(opts []*unit.UnitOption) []string {
+	var names []string
+	for _, val := range getAllOptions(opts, "Install", "Also") {
+		for _, field := range strings.Fields(val) {
+			names = append(names, strings.TrimSuffix(field, ".service"))
+		}
+	}
+	return names
+}
+
+// aliases 返回[Install]中Alias=列出的别名（完整文件名），每个值可包含多个以空格分隔的别名。
+func aliases(opts []*unit.UnitOption) []string {
+	var names []string
+	for _, val := range getAllOptions(opts, "Install", "Alias") {
+		names = append(names, strings.Fields(val)...)
+	}
+	return names
+}
+
+// linkAliases 为Alias=中的每个别名在localUnitPath中创建指向单元文件的符号链接，
+// 与systemd一致别名必须与单元的类型后缀相同。
+func linkAliases(name, path string, opts []*unit.UnitOption) error {
+	file := unitFileName(name)
+	for _, alias := range aliases(opts) {
+		if strings.Contains(alias, "/") || filepath.Ext(alias) != filepath.Ext(file) {
+			return fmt.Errorf("invalid Alias= %q in %s", alias, file)
+		}
+		if alias == file {
+			continue
+		}
+		if err := os.Symlink(path, filepath.Join(localUnitPath, alias)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlinkAliases 删除Alias=在localUnitPath中创建的符号链接，同名的普通文件不会被删除。
+func unlinkAliases(opts []*unit.UnitOption) {
+	for _, alias := range aliases(opts) {
+		if strings.Contains(alias, "/") {
+			continue
+		}
+		link := filepath.Join(localUnitPath, alias)
+		if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			_ = os.Remove(link)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// ipAddressAliases 是IPAddressAllow=/IPAddressDeny=支持的特殊名称及其对应的网段
+var ipAddressAliases = map[string][]string{
+	"any":        {"0.0.0.0/0", "::/0"},
+	"localhost":  {"127.0.0.0/8", "::1/128"},
+	"link-local": {"169.254.0.0/16", "fe80::/64"},
+	"multicast":  {"224.0.0.0/4", "ff00::/8"},
+}
+
+// parseIPAddressList 解析IPAddressAllow=或IPAddressDeny=的值，返回网段列表。
+// 单个地址按主机地址处理，无法解析的条目返回错误。
+func parseIPAddressList(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, val := range values {
+		for _, field := range strings.Fields(val) {
+			cidrs, ok := ipAddressAliases[field]
+			if !ok {
+				cidrs = []string{field}
+			}
+			for _, cidr := range cidrs {
+				if !strings.Contains(cidr, "/") {
+					ip := net.ParseIP(cidr)
+					if ip == nil {
+						return nil, fmt.Errorf("invalid IP address: %s", cidr)
+					}
+					bits := 128
+					if ip.To4() != nil {
+						bits = 32
+					}
+					cidr = fmt.Sprintf("%s/%d", cidr, bits)
+				}
+				_, ipNet, err := net.ParseCIDR(cidr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid IP address prefix: %s", field)
+				}
+				nets = append(nets, ipNet)
+			}
+		}
+	}
+	return nets, nil
+}
+
+// ipFilter 是服务的IPAddressAllow=和IPAddressDeny=网段列表。
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseIPFilter 解析服务的IPAddressAllow=和IPAddressDeny=，两者都没有配置时返回nil。
+func parseIPFilter(opts []*unit.UnitOption) (*ipFilter, error) {
+	allow, err := parseIPAddressList(getAllOptions(opts, "Service", "IPAddressAllow"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPAddressAllow: %w", err)
+	}
+	deny, err := parseIPAddressList(getAllOptions(opts, "Service", "IPAddressDeny"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPAddressDeny: %w", err)
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	return &ipFilter{allow: allow, deny: deny}, nil
+}
+
+// program 生成检查数据包对端地址的cgroup_skb程序：egress检查目的地址，ingress检查源地址。
+// 与systemd一致，地址匹配IPAddressAllow=时放行，否则匹配IPAddressDeny=时丢弃，其余放行。
+// 程序返回1放行、0丢弃；非IPv4/IPv6的包和无法读取地址的包一律放行。
+func (f *ipFilter) program(egress bool) ([]bpfInsn, error) {
+	// IPv4和IPv6头中源地址的偏移，目的地址紧随其后
+	v4Offset, v6Offset := int32(12), int32(8)
+	if egress {
+		v4Offset, v6Offset = 16, 24
+	}
+	p := newBPFProgram()
+	p.emit(bpfALU64|bpfMOV|bpfX, 6, 1, 0, 0)
+
+	// 根据IP头第一个字节中的版本号分别处理
+	p.skbLoadBytes(0, 1, "allow")
+	p.emit(bpfLDX|bpfMEM|bpfB, 2, 10, bpfScratch, 0)
+	p.emit(bpfALU64|bpfRSH|bpfK, 2, 0, 0, 4)
+	p.jumpImm(bpfJEQ, 2, 4, "ipv4")
+	p.jumpImm(bpfJEQ, 2, 6, "ipv6")
+	p.jumpImm(bpfJA, 0, 0, "allow")
+
+	// IPv4地址装入r2，与每个网段比较：(r2 & mask) == network
+	p.label("ipv4")
+	p.skbLoadBytes(v4Offset, net.IPv4len, "allow")
+	p.emit(bpfLDX|bpfMEM|bpfW, 2, 10, bpfScratch, 0)
+	for _, list := range []struct {
+		nets   []*net.IPNet
+		target string
+	}{{f.allow, "allow"}, {f.deny, "deny"}} {
+		for _, n := range list.nets {
+			ip := n.IP.To4()
+			if ip == nil || len(n.Mask) != net.IPv4len {
+				continue
+			}
+			p.loadImm64(3, nativeUint32(n.Mask))
+			p.emit(bpfALU64|bpfAND|bpfX, 3, 2, 0, 0)
+			p.loadImm64(4, nativeUint32(ip.Mask(n.Mask)))
+			p.jump(bpfJEQ, 3, 4, list.target)
+		}
+	}
+	p.jumpImm(bpfJA, 0, 0, "allow")
+
+	// IPv6地址的前后两半分别装入r2和r5，两半都匹配才算匹配网段
+	p.label("ipv6")
+	p.skbLoadBytes(v6Offset, net.IPv6len, "allow")
+	p.emit(bpfLDX|bpfMEM|bpfDW, 2, 10, bpfScratch, 0)
+	p.emit(bpfLDX|bpfMEM|bpfDW, 5, 10, bpfScratch+8, 0)
+	for _, list := range []struct {
+		nets   []*net.IPNet
+		target string
+	}{{f.allow, "allow"}, {f.deny, "deny"}} {
+		for _, n := range list.nets {
+			if n.IP.To4() != nil || len(n.Mask) != net.IPv6len {
+				continue
+			}
+			network := n.IP.Mask(n.Mask)
+			next := fmt.Sprintf("next%d", len(p.insns))
+			p.loadImm64(3, nativeUint64(n.Mask[:8]))
+			p.emit(bpfALU64|bpfAND|bpfX, 3, 2, 0, 0)
+			p.loadImm64(4, nativeUint64(network[:8]))
+			p.jump(bpfJNE, 3, 4, next)
+			p.loadImm64(3, nativeUint64(n.Mask[8:]))
+			p.emit(bpfALU64|bpfAND|bpfX, 3, 5, 0, 0)
+			p.loadImm64(4, nativeUint64(network[8:]))
+			p.jump(bpfJEQ, 3, 4, list.target)
+			p.label(next)
+		}
+	}
+	p.jumpImm(bpfJA, 0, 0, "allow")
+
+	p.label("allow")
+	p.emit(bpfALU64|bpfMOV|bpfK, 0, 0, 0, 1)
+	p.emit(bpfJMP|bpfEXIT, 0, 0, 0, 0)
+	// 内核拒绝包含不可达指令的程序，没有IPAddressDeny=时不生成丢弃分支
+	if len(f.deny) > 0 {
+		p.label("deny")
+		p.emit(bpfALU64|bpfMOV|bpfK, 0, 0, 0, 0)
+		p.emit(bpfJMP|bpfEXIT, 0, 0, 0, 0)
+	}
+	return p.assemble()
+}
+
+// installIPFilter 在cgroup v2层级中为服务创建cgroup，并在其上挂载ingress和egress两个方向的地址过滤程序，
+// 返回服务进程需要加入的cgroup目录。过滤程序随cgroup一起在removeCgroup时释放。
+func installIPFilter(service string, filter *ipFilter) (string, error) {
+	root := unifiedCgroupRoot()
+	if root == "" {
+		return "", errors.New("no cgroup v2 hierarchy mounted at " + cgroupRoot)
+	}
+	dir := filepath.Join(root, cgroupParent, service)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = cgroup.Close() }()
+	for _, attachType := range []uint32{bpfCgroupInetIngress, bpfCgroupInetEgress} {
+		egress := attachType == bpfCgroupInetEgress
+		insns, err := filter.program(egress)
+		if err != nil {
+			return "", err
+		}
+		name := "ip_ingress"
+		if egress {
+			name = "ip_egress"
+		}
+		prog, err := loadCgroupSKBProgram(name, insns, attachType)
+		if err != nil {
+			return "", err
+		}
+		// 挂载后cgroup持有程序的引用，不再需要这个文件描述符
+		err = attachCgroupProgram(int(cgroup.Fd()), prog, attachType)
+		_ = syscall.Close(prog)
+		if err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
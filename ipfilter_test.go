@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestParseIPAddressList(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, nil, false},
+		{[]string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, false},
+		{[]string{"192.168.1.7"}, []string{"192.168.1.7/32"}, false},
+		{[]string{"192.168.1.7/24"}, []string{"192.168.1.0/24"}, false},
+		{[]string{"::1"}, []string{"::1/128"}, false},
+		{[]string{"2001:db8::/32"}, []string{"2001:db8::/32"}, false},
+		{[]string{"10.0.0.1 10.0.0.2", "172.16.0.0/12"}, []string{"10.0.0.1/32", "10.0.0.2/32", "172.16.0.0/12"}, false},
+		{[]string{"any"}, []string{"0.0.0.0/0", "::/0"}, false},
+		{[]string{"localhost"}, []string{"127.0.0.0/8", "::1/128"}, false},
+		{[]string{"link-local multicast"}, []string{"169.254.0.0/16", "fe80::/64", "224.0.0.0/4", "ff00::/8"}, false},
+		{[]string{"example.com"}, nil, true},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"10.0.0.256"}, nil, true},
+		{[]string{"10.0.0.1 bogus"}, nil, true},
+	}
+	for _, tt := range tests {
+		nets, err := parseIPAddressList(tt.values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIPAddressList(%q) error = %v, wantErr %v", tt.values, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, n := range nets {
+			got = append(got, n.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseIPAddressList(%q) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+// attachedPrograms 通过BPF_PROG_QUERY返回挂载在cgroup目录上指定方向的程序数量。
+func attachedPrograms(t *testing.T, dir string, attachType uint32) int {
+	t.Helper()
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	attr := struct {
+		targetFd, attachType, queryFlags, attachFlags uint32
+		progIDs                                       uint64
+		progCnt                                       uint32
+		_                                             uint32
+	}{targetFd: uint32(f.Fd()), attachType: attachType}
+	const bpfProgQuery = 16
+	if _, err = bpfSyscall(bpfProgQuery, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		t.Fatal(err)
+	}
+	return int(attr.progCnt)
+}
+
+// TestIPAddressFilter 在真实的cgroup v2层级中启动服务，检查过滤程序被挂载到服务的cgroup上，
+// 并且被IPAddressDeny=拒绝的服务无法连接本机的监听端口，而IPAddressAllow=放行的服务可以。
+// 需要root权限以及加载BPF程序的能力，否则跳过。
+func TestIPAddressFilter(t *testing.T) {
+	dir := setupTestPaths(t)
+	cgroupRoot = "/sys/fs/cgroup"
+	if unifiedCgroupRoot() == "" {
+		t.Skip("no cgroup v2 hierarchy mounted")
+	}
+	if _, err := os.Stat("/bin/bash"); err != nil {
+		t.Skip("bash is not available")
+	}
+	insns, err := (&ipFilter{}).program(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := loadCgroupSKBProgram("probe", insns, bpfCgroupInetIngress)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOSYS) {
+		t.Skipf("BPF programs cannot be loaded: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	_ = syscall.Close(prog)
+	parent := filepath.Join(unifiedCgroupRoot(), cgroupParent)
+	t.Cleanup(func() { _ = os.Remove(parent) })
+
+	writeUnit(t, "filtered.service", "[Service]\nIPAddressDeny=any\nExecStart=/bin/sleep 60\n")
+	if err = Start("filtered"); err != nil {
+		t.Fatal(err)
+	}
+	cgroup := filepath.Join(parent, "filtered")
+	for _, attachType := range []uint32{bpfCgroupInetIngress, bpfCgroupInetEgress} {
+		if n := attachedPrograms(t, cgroup, attachType); n != 1 {
+			t.Errorf("%d programs attached to %s for attach type %d, want 1", n, cgroup, attachType)
+		}
+	}
+	lock.Lock()
+	pid := strconv.Itoa(mapCommand["filtered"].Process.Pid)
+	lock.Unlock()
+	if procs, _ := os.ReadFile(filepath.Join(cgroup, "cgroup.procs")); !slices.Contains(strings.Fields(string(procs)), pid) {
+		t.Errorf("main process %s is not in %s: %q", pid, cgroup, procs)
+	}
+	if err = Stop("filtered"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(cgroup); !os.IsNotExist(err) {
+		t.Errorf("cgroup %s still exists after stop: %v", cgroup, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	probe := filepath.Join(dir, "probe.sh")
+	script := "#!/bin/bash\nif timeout 1 bash -c \"echo > /dev/tcp/127.0.0.1/$1\" 2>/dev/null; then echo connected > \"$2\"; else echo refused > \"$2\"; fi\n"
+	if err = os.WriteFile(probe, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, filter, want string
+	}{
+		{"denied", "IPAddressDeny=localhost\n", "refused"},
+		{"allowed", "IPAddressAllow=localhost\nIPAddressDeny=any\n", "connected"},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, tt.name)
+		writeUnit(t, tt.name+".service", "[Service]\nType=oneshot\n"+tt.filter+"ExecStart="+probe+" "+port+" "+out+"\n")
+		if err = Start(tt.name); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := os.ReadFile(out); strings.TrimSpace(string(data)) != tt.want {
+			t.Errorf("%s: connection %q, want %q", tt.name, strings.TrimSpace(string(data)), tt.want)
+		}
+	}
+}
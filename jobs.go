@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// job 表示守护进程中一个正在排队或执行的操作。
+type job struct {
+	// id 是按提交顺序递增的任务编号
+	id int
+	// unit 是任务作用的单元的完整名称，带.service、.socket等后缀
+	unit string
+	// op 是任务类型（start、stop等）
+	op string
+}
+
+var (
+	// jobs 跟踪当前所有未完成的任务
+	jobs = map[int]*job{}
+	// nextJobID 是下一个任务的编号
+	nextJobID = 1
+	// jobLock 保护对jobs和nextJobID的并发访问
+	jobLock sync.Mutex
+)
+
+// jobOps 列出会作为任务跟踪的操作类型
+var jobOps = map[string]bool{
+	"start":             true,
+	"stop":              true,
+	"restart":           true,
+	"try-restart":       true,
+	"reload":            true,
+	"reload-or-restart": true,
+}
+
+// addJob 登记一个新任务并返回它，任务完成后必须调用removeJob。服务名称不带后缀。
+func addJob(unit, op string) *job {
+	jobLock.Lock()
+	defer jobLock.Unlock()
+	j := &job{id: nextJobID, unit: unitFileName(unit), op: op}
+	nextJobID++
+	jobs[j.id] = j
+	return j
+}
+
+// removeJob 在任务完成后将其移除。
+func removeJob(j *job) {
+	jobLock.Lock()
+	defer jobLock.Unlock()
+	delete(jobs, j.id)
+}
+
+// ListJobs 以systemctl list-jobs的格式返回所有未完成的任务。
+// 不同单元的任务由各自的服务锁保护、可以并行执行，同一单元的任务则依次执行，
+// 因此每个单元最早提交的任务处于running状态，同一单元之后提交的任务为waiting。
+func ListJobs() string {
+	jobLock.Lock()
+	list := make([]*job, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j)
+	}
+	jobLock.Unlock()
+
+	if len(list) == 0 {
+		return "No jobs running."
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].id < list[k].id })
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%-5s %-30s %-8s %s\n", "JOB", "UNIT", "TYPE", "STATE")
+	running := map[string]bool{}
+	for _, j := range list {
+		state := "waiting"
+		if !running[j.unit] {
+			running[j.unit] = true
+			state = "running"
+		}
+		_, _ = fmt.Fprintf(&b, "%-5d %-30s %-8s %s\n", j.id, j.unit, j.op, state)
+	}
+	_, _ = fmt.Fprintf(&b, "\n%d jobs listed.", len(list))
+	return b.String()
+}
+
+// pendingJob 返回作用于单元的最早一个未完成任务的类型，没有任务时返回空字符串。
+func pendingJob(unit string) string {
+	name := unitFileName(unit)
+	jobLock.Lock()
+	defer jobLock.Unlock()
+	op, id := "", 0
+	for _, j := range jobs {
+		if j.unit == name && (id == 0 || j.id < id) {
+			op, id = j.op, j.id
+		}
+	}
+	return op
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hasJob 判断list-jobs的输出中是否有指定单元、类型和状态的任务。
+func hasJob(list, unit, op, state string) bool {
+	for _, line := range strings.Split(list, "\n") {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[1] == unit && fields[2] == op && fields[3] == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestListJobs(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "slow.service", "[Service]\nRestart=always\nExecStartPre=/bin/sleep 0.5\nExecStart=/bin/sleep 60\n")
+	writeUnit(t, "other.service", "[Service]\nRestart=always\nExecStartPre=/bin/sleep 0.5\nExecStart=/bin/sleep 60\n")
+	if list := ListJobs(); list != "No jobs running." {
+		t.Fatalf("jobs listed before any operation:\n%s", list)
+	}
+
+	var wg sync.WaitGroup
+	run := func(op, service string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dispatch(op, service, nil); err != nil {
+				t.Errorf("%s %s: %v", op, service, err)
+			}
+		}()
+	}
+	run("start", "slow")
+	waitFor(t, time.Second, "start job", func() bool { return hasJob(ListJobs(), "slow.service", "start", "running") })
+	run("stop", "slow")
+	run("start", "other")
+
+	var list string
+	waitFor(t, time.Second, "all jobs to be listed", func() bool {
+		list = ListJobs()
+		return strings.HasSuffix(list, "3 jobs listed.")
+	})
+	for _, want := range [][3]string{
+		{"slow.service", "start", "running"},
+		{"slow.service", "stop", "waiting"},
+		{"other.service", "start", "running"},
+	} {
+		if !hasJob(list, want[0], want[1], want[2]) {
+			t.Errorf("list-jobs has no %s job for %s in state %s:\n%s", want[1], want[0], want[2], list)
+		}
+	}
+
+	wg.Wait()
+	if list := ListJobs(); list != "No jobs running." {
+		t.Errorf("jobs left after all operations finished:\n%s", list)
+	}
+}
+
+// TestListJobsUnitSuffix 检查socket和timer单元的任务以完整的单元名称列出，不与同名服务的任务混在一起。
+func TestListJobsUnitSuffix(t *testing.T) {
+	setupTestPaths(t)
+	for _, unit := range []string{"web.socket", "web", "backup.timer"} {
+		j := addJob(unit, "start")
+		t.Cleanup(func() { removeJob(j) })
+	}
+	list := ListJobs()
+	for _, unit := range []string{"web.socket", "web.service", "backup.timer"} {
+		if !hasJob(list, unit, "start", "running") {
+			t.Errorf("list-jobs has no running start job for %s:\n%s", unit, list)
+		}
+	}
+	if strings.Contains(list, ".socket.service") || strings.Contains(list, ".timer.service") {
+		t.Errorf("list-jobs appends .service to socket or timer units:\n%s", list)
+	}
+	if op := pendingJob("web"); op != "start" {
+		t.Errorf("pendingJob(web) = %q, want start", op)
+	}
+	if op := pendingJob("backup"); op != "" {
+		t.Errorf("pendingJob(backup) = %q, want no job", op)
+	}
+}
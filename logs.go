@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// defaultLogLines 是logs命令未指定-n时返回的行数
+const defaultLogLines = 10
+
+// Logs 返回服务输出日志的最后n行。
+func Logs(service string, n int) (string, error) {
+	opts, err := loadUnit(service)
+	if err != nil {
+		return "", err
+	}
+	path := logFile(service, opts)
+	if path == "" || path == os.DevNull {
+		return "", errors.New("output of service is not captured to a file")
+	}
+	data, err := tailFile(path, n)
+	if os.IsNotExist(err) {
+		return "-- No entries --", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(data, []byte("\n"))), nil
+}
+
+// tailFile 从文件末尾按块向前读取，返回最后n行，不会读取整个文件。
+// 返回内容加上响应的状态字节不超过maxFrameSize，以便能通过一帧发送给客户端；
+// 超出时丢弃较早的行，截断后从完整的一行开始。
+func tailFile(path string, n int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	const chunkSize = 32 << 10
+	offset := info.Size()
+	var data []byte
+	for offset > 0 && int64(len(data)) < maxFrameSize {
+		size := int64(chunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err = file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(chunk, data...)
+		// 末尾的换行不算作新的一行
+		if bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) >= n {
+			break
+		}
+	}
+
+	trimmed := bytes.TrimSuffix(data, []byte("\n"))
+	for i := len(trimmed) - 1; i >= 0; i-- {
+		if trimmed[i] != '\n' {
+			continue
+		}
+		n--
+		if n == 0 {
+			data = data[i+1:]
+			break
+		}
+	}
+	return limitFrame(data), nil
+}
+
+// limitFrame 保留data末尾不超过maxFrameSize-1字节的内容，并从截断处之后的第一行开始。
+// 只有一行超长时保留这一行的末尾。
+func limitFrame(data []byte) []byte {
+	const limit = maxFrameSize - 1
+	if int64(len(data)) <= limit {
+		return data
+	}
+	start := int64(len(data)) - limit
+	if data[start-1] != '\n' {
+		// 截断处在一行中间，跳过这一行的剩余部分
+		if i := bytes.IndexByte(data[start:], '\n'); i >= 0 && start+int64(i)+1 < int64(len(data)) {
+			start += int64(i) + 1
+		}
+	}
+	return data[start:]
+}
@@ -5,14 +5,19 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,54 +26,170 @@ import (
 
 // 遵循systemd约定的全局配置路径
 var (
-	// sysPath 是系统级的systemd服务文件目录
-	sysPath = "/usr/lib/systemd/system"
-	// usrPath 是本地systemd服务文件目录
-	usrPath = "/etc/systemd/system"
+	// localUnitPath 是管理员维护的本地单元目录，优先于vendorUnitPath，
+	// 屏蔽链接、drop-in覆盖和default.target也写在这里
+	localUnitPath = "/etc/systemd/system"
+	// vendorUnitPath 是软件包安装的单元目录，同名单元被localUnitPath中的覆盖
+	vendorUnitPath = "/usr/lib/systemd/system"
+	// unitSearchPath 是以":"分隔的单元文件搜索路径，按顺序查找、先找到的优先；
+	// 为空时使用localUnitPath和vendorUnitPath，以":"结尾时在其后追加这两个默认目录
+	unitSearchPath = ""
 	// enablePath 是已启用服务的目录（符号链接）
 	enablePath = "/etc/systemd/system/multi-user.target.wants"
+	// logPath 是服务默认输出日志的目录
+	logPath = "/var/log/systemctl"
+	// runtimePath 是RuntimeDirectory=所在的目录
+	runtimePath = "/run"
+	// statePath 是保存运行中服务PID的状态文件，用于daemon-reexec和守护进程崩溃后的恢复
+	statePath = "/run/systemctl-state.json"
 	// socketPath 是守护进程通信的Unix套接字路径
 	socketPath = "/etc/systemd/systemctl.sock"
+	// bootJobs 是开机时同时启动的服务数上限，为空时使用defaultBootConcurrency
+	bootJobs = ""
+	// tokenPath 是控制协议的共享令牌文件，存在时守护进程拒绝没有携带该令牌的请求
+	tokenPath = "/etc/systemd/systemctl.token"
+	// tcpAddress 是守护进程额外监听的TCP地址（host:port），为空表示不监听
+	tcpAddress = ""
+	// remoteAddress 是客户端连接的守护进程TCP地址，为空时通过socketPath连接本机守护进程
+	remoteAddress = ""
 	// mapCommand 跟踪正在运行的服务及其进程
 	mapCommand = map[string]*exec.Cmd{}
-	// lock 保护对mapCommand的并发访问
+	// mapState 记录每个服务的运行时状态（如状态切换时间戳）
+	mapState = map[string]*serviceState{}
+	// exitRequested 表示收到poweroff/halt，当前请求响应后守护进程退出
+	exitRequested atomic.Bool
+	// reexecRequested 表示收到daemon-reexec，当前请求响应后守护进程重新执行自身
+	reexecRequested atomic.Bool
+	// shuttingDown 表示守护进程正在关闭，此后不再启动任何服务。受lock保护
+	shuttingDown bool
+	// lock 保护对mapCommand和mapState的并发访问
 	lock sync.Mutex
 )
 
-// reapZombies 持续回收僵尸进程以防止资源泄露。
-// 此函数在goroutine中运行，检查已终止的子进程。
-func reapZombies() {
-	for {
-		var status syscall.WaitStatus
-		// WNOHANG: 非阻塞模式，如果没有僵尸进程立即返回
-		// -1: 等待任何子进程
-		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
-		if err != nil || pid <= 0 {
-			// 没有僵尸进程或发生错误
-			time.Sleep(1 * time.Second)
-			continue
-		}
-		log.Printf("Reaped zombie process PID: %d\n", pid)
+// serviceState 保存单个服务在守护进程中的运行时状态。
+type serviceState struct {
+	// activeEnter 是服务最近一次进入运行状态的时间
+	activeEnter time.Time
+	// inactiveEnter 是服务最近一次进入非运行状态的时间
+	inactiveEnter time.Time
+	// serviceType 是最近一次启动时的Type=（simple、forking等）
+	serviceType string
+	// notify 是Type=notify服务当前实例的通知套接字
+	notify *notifySocket
+	// exitStatus 是主进程最近一次退出的等待状态，exitKnown为false表示尚未退出过或状态不可用
+	exitStatus syscall.WaitStatus
+	exitKnown  bool
+	// starts 是StartLimitIntervalSec窗口内的启动时间，用于启动频率限制
+	starts []time.Time
+	// startLimitHit 表示服务因启动过于频繁被标记为失败，需reset-failed后才能再次启动
+	startLimitHit bool
+	// failed 表示服务最近一次启动失败或以失败退出，再次成功启动、停止或reset-failed后清除
+	failed bool
+	// result 是最近一次启动或运行的结果，与systemd的Result=一致：success、exit-code、signal、
+	// core-dump、watchdog、start-limit-hit，Assert*=不满足时的assert，以及启动进程之前就失败（如可执行文件不存在）时的resources
+	result string
+	// condition 是最近一次启动时不满足的Condition*=，为空表示条件满足
+	condition string
+	// assert 是最近一次启动时不满足的Assert*=，此时启动失败
+	assert string
+	// autoRestarts 是连续自动重启的次数，用于计算RestartSteps=的退避时间，显式停止后清零
+	autoRestarts int
+	// restarts 是服务被重启的总次数（自动重启和restart命令），即NRestarts=，reset-failed后清零
+	restarts int
+	// starting 表示服务正在等待就绪（forking或notify），此时ActiveState为activating
+	starting bool
+	// stopping 是正在被终止的实例，它的退出是预期的，不触发自动重启
+	stopping *exec.Cmd
+	// collectMode 是最近一次启动时的CollectMode=，单元文件删除后决定是否回收失败的服务
+	collectMode string
+	// listenFiles 是socket激活时传给服务的监听套接字，由对应的.socket单元设置
+	listenFiles []*os.File
+	// pidFile 是当前实例的PIDFile=，其中的PID优先作为主进程PID，服务停止后清除
+	pidFile string
+	// frozen 表示服务已被freeze挂起，thaw或服务停止后清除
+	frozen bool
+	// health 是X-HealthCheck=的最近结果：healthy或unhealthy，为空表示未配置或尚未检查
+	health string
+	// crashes 是X-CrashLoopIntervalSec窗口内运行时间过短就退出的时间，用于崩溃循环检测
+	crashes []time.Time
+	// crashLoop 描述检测到的崩溃循环，不为空时服务不再自动重启，reset-failed后清除
+	crashLoop string
+	// crashLoopDisabled 表示服务因崩溃循环被X-CrashLoopDisable=禁用
+	crashLoopDisabled bool
+}
+
+// getState 返回服务的运行时状态，不存在时创建。调用方必须持有lock。
+func getState(service string) *serviceState {
+	state := mapState[service]
+	if state == nil {
+		state = &serviceState{}
+		mapState[service] = state
+	}
+	return state
+}
+
+// usage 是命令行用法说明
+const usage = "Usage: systemctl [--socket|--listen-tcp|--host|--unit-path|--system-unit-path|--unit-search-path|--enable-path|--log-path|--runtime-path|--state-path|--token-path path] [--boot-concurrency n]... [--dry-run|--no-block] [enable|disable|mask|unmask|start|stop|restart|try-restart|reload|reload-or-restart|status|show|kill|freeze|thaw|logs|reset-failed|edit|list-dependencies|list-jobs|list-units|list-unit-files|daemon-reload|daemon-reexec|isolate|get-default|set-default|preflight|start-order|verify|show-environment|set-environment|unset-environment|poweroff|halt|reboot|soft-reboot|batch|pipeline|domain] [service]"
+
+// invokedAs 返回程序名argv0对应的别名命令（reboot、poweroff或halt），不是别名时返回空字符串。
+// 只比较程序的文件名，路径中的目录（如/opt/reboot-tools/systemctl）不影响结果。
+func invokedAs(argv0 string) string {
+	switch name := filepath.Base(argv0); name {
+	case "reboot", "poweroff", "halt":
+		return name
 	}
+	return ""
 }
 
 // main 是systemctl程序的入口点。
 // 它处理命令行参数并将它们路由到适当的函数。
 func main() {
-	args := os.Args
+	args, err := parseGlobalFlags(os.Args)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
-	// 当以"reboot"调用时处理重启命令
-	if strings.Contains(os.Args[0], "reboot") {
-		send("reboot", "reboot")
+	// 以"reboot"、"poweroff"或"halt"为程序名（如通过符号链接）调用时执行对应的命令
+	switch op := invokedAs(os.Args[0]); op {
+	case "reboot":
+		_, _ = send("reboot", "reboot")
+		return
+	case "poweroff", "halt":
+		// 停止所有服务并退出守护进程
+		output(send(op, op))
 		return
 	}
 
 	// 至少需要一个参数
 	if len(args) < 2 {
-		fmt.Println("Usage: systemctl [enable|disable|start|stop|restart|status|domain] [service]")
+		fmt.Println(usage)
 		return
 	}
 
+	// --dry-run 让守护进程只描述start、stop或restart将要执行的操作
+	if args[1] == "start" || args[1] == "stop" || args[1] == "restart" {
+		if i := slices.Index(args, "--dry-run"); i >= 0 {
+			args = slices.Delete(args, i, i+1)
+			if len(args) < 3 {
+				fmt.Println("Error: service name required")
+				return
+			}
+			output(send(args[2], args[1], "dry-run"))
+			return
+		}
+		// --no-block 让守护进程在后台执行操作，客户端立即返回，操作失败不会报告给调用方
+		if i := slices.Index(args, "--no-block"); i >= 0 {
+			args = slices.Delete(args, i, i+1)
+			if len(args) < 3 {
+				fmt.Println("Error: service name required")
+				return
+			}
+			output(send(args[2], args[1], "no-block"))
+			return
+		}
+	}
+
 	// 将命令路由到适当的处理程序
 	switch args[1] {
 	case "enable":
@@ -76,53 +197,312 @@ func main() {
 			fmt.Println("Error: service name required")
 			return
 		}
+		// --force 允许启用没有[Install]节的单元
+		if len(args) > 3 && (args[3] == "--force" || args[3] == "-f") {
+			log.Printf("Enabling service: %s\n", args[2])
+			output(send(args[2], "enable", "force"))
+			return
+		}
 		log.Printf("Enabling service: %s\n", args[2])
-		fmt.Println(send(args[2], "enable"))
+		output(send(args[2], "enable"))
 	case "disable":
 		if len(args) < 3 {
 			fmt.Println("Error: service name required")
 			return
 		}
 		log.Printf("Disabling service: %s\n", args[2])
-		fmt.Println(send(args[2], "disable"))
+		output(send(args[2], "disable"))
 	case "start":
 		if len(args) < 3 {
 			fmt.Println("Error: service name required")
 			return
 		}
 		log.Printf("Starting service: %s\n", args[2])
-		fmt.Println(send(args[2], "start"))
+		output(send(args[2], "start"))
 	case "stop":
 		if len(args) < 3 {
 			fmt.Println("Error: service name required")
 			return
 		}
 		log.Printf("Stopping service: %s\n", args[2])
-		fmt.Println(send(args[2], "stop"))
+		output(send(args[2], "stop"))
 	case "restart":
 		if len(args) < 3 {
 			fmt.Println("Error: service name required")
 			return
 		}
 		log.Printf("Restarting service: %s\n", args[2])
-		fmt.Println(send(args[2], "restart"))
+		output(send(args[2], "restart"))
+	case "try-restart":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Trying to restart service: %s\n", args[2])
+		output(send(args[2], "try-restart"))
+	case "reload-or-restart":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Reloading or restarting service: %s\n", args[2])
+		output(send(args[2], "reload-or-restart"))
+	case "reload":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Reloading service: %s\n", args[2])
+		output(send(args[2], "reload"))
 	case "status":
 		if len(args) < 3 {
 			fmt.Println("Error: service name required")
 			return
 		}
+		if args[2] == "--follow" || args[2] == "-f" {
+			if len(args) < 4 {
+				fmt.Println("Error: service name required")
+				return
+			}
+			log.Printf("Following service status: %s\n", args[3])
+			output("", follow(strings.TrimSuffix(args[3], ".service")))
+			return
+		}
 		log.Printf("Checking service status: %s\n", args[2])
-		fmt.Println(send(args[2], "status"))
+		output(send(args[2], "status"))
+	case "show":
+		service, names, err := parseShowArgs(args[2:])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		log.Printf("Showing properties of service: %s\n", service)
+		output(send(service, "show", names...))
+	case "freeze", "thaw":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Updating freezer state of service %s: %s\n", args[2], args[1])
+		output(send(args[2], args[1]))
+	case "kill":
+		// 解析 -s/--signal 参数，默认发送SIGTERM
+		sig := "SIGTERM"
+		service := ""
+		for i := 2; i < len(args); i++ {
+			switch {
+			case args[i] == "-s" || args[i] == "--signal":
+				if i+1 < len(args) {
+					sig = args[i+1]
+					i++
+				}
+			case strings.HasPrefix(args[i], "--signal="):
+				sig = strings.TrimPrefix(args[i], "--signal=")
+			default:
+				service = args[i]
+			}
+		}
+		if service == "" {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Sending signal %s to service: %s\n", sig, service)
+		output(send(service, "kill", sig))
+	case "edit":
+		// 使用 --stdin 时从标准输入读取drop-in内容，否则在编辑器中编辑override.conf
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		service := strings.TrimSuffix(args[2], ".service")
+		var content []byte
+		if len(args) > 3 && args[3] == "--stdin" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = editOverride(service)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if content == nil {
+			fmt.Println("Edit cancelled, no changes made.")
+			return
+		}
+		log.Printf("Editing service: %s\n", service)
+		output(request(fmt.Sprintf("edit:%s\n%s", service, content)))
+	case "logs":
+		// 解析 -n/--lines 参数，默认显示最后10行
+		lines := strconv.Itoa(defaultLogLines)
+		service := ""
+		for i := 2; i < len(args); i++ {
+			switch {
+			case args[i] == "-n" || args[i] == "--lines":
+				if i+1 < len(args) {
+					lines = args[i+1]
+					i++
+				}
+			case strings.HasPrefix(args[i], "--lines="):
+				lines = strings.TrimPrefix(args[i], "--lines=")
+			default:
+				service = args[i]
+			}
+		}
+		if service == "" {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Reading logs of service: %s\n", service)
+		output(send(service, "logs", lines))
+	case "mask", "unmask":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Updating mask of service %s: %s\n", args[2], args[1])
+		output(send(args[2], args[1]))
+	case "reset-failed":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Resetting failed state of service: %s\n", args[2])
+		output(send(args[2], "reset-failed"))
+	case "list-dependencies":
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		log.Printf("Listing dependencies of service: %s\n", args[2])
+		output(send(args[2], "list-dependencies"))
+	case "list-jobs":
+		log.Println("Listing jobs")
+		output(send("", "list-jobs"))
+	case "list-unit-files":
+		log.Println("Listing unit files")
+		output(send("", "list-unit-files"))
+	case "list-units":
+		states, err := parseListUnitsArgs(args[2:])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		log.Println("Listing units")
+		output(send("", "list-units", states...))
+	case "preflight":
+		// 在本地校验单元文件，不需要守护进程，便于在CI中使用
+		report, ok := Preflight()
+		fmt.Println(report)
+		if !ok {
+			os.Exit(1)
+		}
+	case "start-order":
+		// 在本地计算开机启动计划，不需要守护进程
+		fmt.Println(StartOrder())
+	case "verify":
+		// 在本地校验单元文件，不需要守护进程
+		if len(args) < 3 {
+			fmt.Println("Error: service name required")
+			return
+		}
+		ok := true
+		for _, service := range args[2:] {
+			report, passed := Verify(strings.TrimSuffix(service, ".service"))
+			fmt.Println(report)
+			ok = ok && passed
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	case "show-environment":
+		log.Println("Showing manager environment")
+		output(send("", "show-environment"))
+	case "set-environment", "unset-environment":
+		// 变量以换行分隔放在消息体中，避免值中的":"与消息分隔符冲突
+		if len(args) < 3 {
+			fmt.Println("Error: environment variable required")
+			return
+		}
+		log.Printf("Updating manager environment: %s\n", args[1])
+		output(request(fmt.Sprintf("%s:\n%s", args[1], strings.Join(args[2:], "\n"))))
+	case "daemon-reload":
+		// --clean-symlinks 表示同时删除检测到的悬空启用链接
+		if len(args) > 2 && args[2] == "--clean-symlinks" {
+			log.Println("Reloading daemon and cleaning dangling symlinks")
+			output(send("", "daemon-reload", "clean"))
+			return
+		}
+		log.Println("Reloading daemon")
+		output(send("", "daemon-reload"))
+	case "daemon-reexec":
+		log.Println("Re-executing daemon")
+		output(send("", "daemon-reexec"))
+	case "isolate", "set-default":
+		if len(args) < 3 {
+			fmt.Println("Error: target name required")
+			return
+		}
+		target := args[2]
+		if !strings.HasSuffix(target, ".target") {
+			target += ".target"
+		}
+		log.Printf("Requesting %s: %s\n", args[1], target)
+		output(send(target, args[1]))
+	case "get-default":
+		output(send("", "get-default"))
+	case "batch":
+		// 从标准输入读取批量操作，--atomic 表示失败时回滚
+		atomic := len(args) > 2 && args[2] == "--atomic"
+		msg, err := buildBatch(os.Stdin, atomic)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		log.Println("Sending batch request")
+		output(request(msg))
+	case "pipeline":
+		// 从标准输入逐行读取命令，在同一个连接上依次发送，避免每条命令重新连接
+		ok, err := Pipeline(os.Stdin, os.Stdout)
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	case "poweroff", "halt":
+		log.Printf("Requesting %s\n", args[1])
+		output(send(args[1], args[1]))
+	case "soft-reboot":
+		// 停止所有服务后在守护进程内重新执行开机启动，守护进程本身不退出
+		log.Println("Requesting soft-reboot")
+		output(send("", "soft-reboot"))
+	case "reboot":
+		// 守护进程直接退出，作为容器的PID 1时容器随之停止，由容器运行时决定是否重启
+		log.Println("Requesting reboot")
+		_, _ = send("reboot", "reboot")
 	case "domain":
 		log.Println("Starting daemon process")
 		// 启动僵尸进程回收器
 		go reapZombies()
 		Domain()
+	case "exec-cgroup":
+		// 内部使用：cgroup v1中受资源限制的服务经由此处加入cgroup后exec真正的程序
+		if err := execCgroup(args[2:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	case "exec-listen":
+		// 内部使用：socket激活的服务经由此处设置LISTEN_PID后exec真正的程序
+		if err := execListen(args[2:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 	case "--version":
 		fmt.Println("systemd 226")
 	default:
 		fmt.Printf("Unknown command: %s\n", args[1])
-		fmt.Println("Usage: systemctl [enable|disable|start|stop|restart|status|domain] [service]")
+		fmt.Println(usage)
 	}
 }
 
@@ -135,9 +515,51 @@ func parseSystemdService(content string) ([]*unit.UnitOption, error) {
 	return opts, nil
 }
 
+// loadUnit 定位、读取并解析服务的单元文件，并合并drop-in目录中的覆盖片段。
+// 选项值中的%n、%i、%H等说明符会被展开。
+func loadUnit(service string) ([]*unit.UnitOption, error) {
+	path := find(service)
+	if path == "" {
+		return nil, errors.New("no service found")
+	}
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseSystemdService(string(file))
+	if err != nil {
+		return nil, err
+	}
+	dropIns, err := loadDropIns(service)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, dropIns...)
+	expandSpecifiers(opts, service)
+	return opts, nil
+}
+
+// workingDirectory 返回服务配置的工作目录，未配置时默认为/root。
+// 以-开头的目录不存在时同样回退到/root。
+func workingDirectory(opts []*unit.UnitOption) string {
+	dir, _ := getOptions(opts, "Service", "WorkingDirectory")
+	if strings.HasPrefix(dir, "-") {
+		dir = strings.TrimPrefix(dir, "-")
+		if _, err := os.Stat(dir); err != nil {
+			dir = ""
+		}
+	}
+	if dir != "" {
+		return dir
+	}
+	return "/root"
+}
+
 // getOptions 从解析的systemd单元选项中检索特定选项值。
+// 选项出现多次时以最后一次为准，使drop-in片段能够覆盖基础单元。
 func getOptions(list []*unit.UnitOption, section string, name string) (string, error) {
-	for _, option := range list {
+	for i := len(list) - 1; i >= 0; i-- {
+		option := list[i]
 		if option.Section == section && option.Name == name {
 			return option.Value, nil
 		}
@@ -145,72 +567,130 @@ func getOptions(list []*unit.UnitOption, section string, name string) (string, e
 	return "", fmt.Errorf("option %s.%s not found", section, name)
 }
 
-// send 通过Unix套接字与守护进程通信。
-// 它发送命令和服务名称，然后返回守护进程的响应。
-func send(service, op string) string {
-	// 连接到Unix域套接字
-	conn, err := net.Dial("unix", socketPath)
+// getAllOptions 返回指定选项的所有值，用于可以出现多次的指令。
+// 与systemd一致，空赋值会清空之前累积的值。
+func getAllOptions(list []*unit.UnitOption, section string, name string) []string {
+	var values []string
+	for _, option := range list {
+		if option.Section == section && option.Name == name {
+			if option.Value == "" {
+				values = nil
+				continue
+			}
+			values = append(values, option.Value)
+		}
+	}
+	return values
+}
+
+// output 打印守护进程的响应。请求失败时将错误写到标准错误并以非0状态退出。
+func output(res string, err error) {
 	if err != nil {
-		return fmt.Sprintf("Error connecting to daemon: %v", err)
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	defer func() { _ = conn.Close() }()
+	fmt.Println(res)
+}
 
+// send 通过Unix套接字与守护进程通信。
+// 它发送命令、服务名称以及可选的附加参数，然后返回守护进程的响应。
+func send(service, op string, extra ...string) (string, error) {
 	// 以"operation:service"格式发送消息
 	msg := fmt.Sprintf("%s:%s", op, service)
-	_, err = conn.Write([]byte(msg))
+	if len(extra) > 0 {
+		msg += ":" + strings.Join(extra, ":")
+	}
+	return request(msg)
+}
+
+// request 将一条原始消息以帧的形式发送给守护进程并返回响应。
+// 守护进程报告操作失败时返回的error包含其错误信息。
+func request(msg string) (string, error) {
+	// 连接到Unix域套接字，指定了远程地址时连接其TCP监听器
+	conn, err := dial()
 	if err != nil {
-		return fmt.Sprintf("Error sending message: %v", err)
+		return "", fmt.Errorf("Error connecting to daemon: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	return roundTrip(conn, clientToken(), msg)
+}
+
+// roundTrip 在已建立的连接上发送一条请求并读取其响应，token不为空时作为请求的第一个字段。
+func roundTrip(conn net.Conn, token, msg string) (string, error) {
+	if token != "" {
+		msg = token + ":" + msg
+	}
+	if err := writeFrame(conn, []byte(msg)); err != nil {
+		return "", fmt.Errorf("Error sending message: %v", err)
 	}
 
 	// 接收守护进程的响应
-	var response [1024]byte
-	n, err := conn.Read(response[:])
+	response, err := readFrame(conn)
 	if err != nil {
-		return fmt.Sprintf("Error reading response: %v", err)
+		return "", fmt.Errorf("Error reading response: %v", err)
 	}
-	return string(response[:n])
+	return decodeResponse(response)
 }
 
 // find 通过在标准systemd目录中搜索来定位服务文件。
-// 它首先检查用户路径，然后回退到系统路径。
+// 它按unitDirs的优先级查找，先检查本地单元目录，再回退到软件包的单元目录。
+// 模板实例（如getty@tty1）没有自己的单元文件时使用模板文件（getty@.service）。
 func find(service string) string {
-	// 首先检查用户定义的服务目录
-	path := fmt.Sprintf("%s/%s.service", usrPath, service)
-	if _, err := os.Stat(path); err == nil {
+	if path := findFile(service); path != "" {
 		return path
 	}
-
-	// 回退到系统服务目录
-	path = fmt.Sprintf("%s/%s.service", sysPath, service)
-	if _, err := os.Stat(path); err == nil {
-		return path
+	if template, _, ok := splitInstance(service); ok {
+		return findFile(template)
 	}
-
-	// 未找到服务文件
 	return ""
 }
 
+// findFile 按unitDirs的顺序查找名为<name>.service的单元文件。
+func findFile(name string) string {
+	return findUnitFile(name + ".service")
+}
+
+// unitDirs 返回按优先级从高到低排列的单元文件目录。所有查找单元文件的地方都以此为准，
+// 与systemd一致/etc（localUnitPath）优先于/usr/lib（vendorUnitPath）。
+func unitDirs() []string {
+	defaults := []string{localUnitPath, vendorUnitPath}
+	if unitSearchPath == "" {
+		return defaults
+	}
+	var dirs []string
+	for _, dir := range strings.Split(unitSearchPath, ":") {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	if strings.HasSuffix(unitSearchPath, ":") {
+		dirs = append(dirs, defaults...)
+	}
+	return dirs
+}
+
 // Domain 启动管理systemd服务的守护进程。
-// 它自动启动已启用的服务并通过Unix套接字监听客户端命令。
+// 它按After=/Before=顺序并行地自动启动已启用的服务，并通过Unix套接字监听客户端命令。
 func Domain() {
-	err := filepath.Walk(enablePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	var err error
+	if authToken, err = loadToken(); err != nil {
+		log.Println("Failed to load token:", err)
+		return
+	}
+	// 先打开已启用socket单元的监听套接字，使随后启动的同名服务也能收到它们
+	for _, name := range enabledUnits(".socket") {
+		if err = StartSocket(name); err != nil {
+			log.Printf("Failed to listen on %s.socket: %v\n", name, err)
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".service") {
-			if info.Name() == "e2scrub_reap.service" {
-				return nil
-			}
-			err = Start(strings.TrimSuffix(info.Name(), ".service"), 5)
-			if err != nil {
-				log.Printf("Failed to auto-start service %s: %v\n", info.Name(), err)
-			}
+	}
+	// daemon-reexec或守护进程崩溃前仍在运行的服务直接接管，不再重复启动
+	adopted := restoreState()
+	go persistState()
+	startDefaultTarget(adopted)
+	for _, name := range enabledUnits(".timer") {
+		if err = StartTimer(name); err != nil {
+			log.Printf("Failed to start %s.timer: %v\n", name, err)
 		}
-		return nil
-	})
-	if err != nil {
-		log.Printf("find server err: %v\n", err)
-		return
 	}
 	// 如果文件已存在，先删除
 	if _, err = os.Stat(socketPath); err == nil {
@@ -223,28 +703,46 @@ func Domain() {
 	listener, err := net.Listen("unix", socketPath)
 	if err != nil {
 		log.Println("Listening failed:", err)
+		return
 	}
 	defer func() { _ = listener.Close() }()
 
-	// 设置文件权限
-	if err = os.Chmod(socketPath, 0777); err != nil {
+	// 设置文件权限，只允许属主和属组访问控制套接字
+	if err = secureSocket(socketPath); err != nil {
 		log.Println("Failed to set file permissions:", err)
 	}
+	if tcpAddress != "" {
+		tcpListener, err2 := listenTCP(tcpAddress)
+		if err2 != nil {
+			log.Println("Listening failed:", err2)
+		} else {
+			go serve(tcpListener)
+		}
+	}
 
-	// 处理中断信号
+	// 处理中断信号：停止接收命令，按依赖的逆序停止所有服务后退出
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-sigCh
-		_ = listener.Close()
+		sig := <-sigCh
+		log.Printf("Received %v, stopping all services\n", sig)
+		// 不关闭监听器：serve返回会使守护进程在服务停止之前退出。删除套接字文件后不会再有新连接
 		_ = os.Remove(socketPath)
+		Shutdown()
+		_ = os.Remove(statePath)
 		os.Exit(0)
 	}()
 
+	serve(listener)
+}
+
+// serve 接受监听器上的连接并逐个交给handleConnection处理，监听器关闭后返回。
+// Unix套接字和TCP监听器使用相同的协议。
+func serve(listener net.Listener) {
 	for {
 		// 接受连接
-		conn, err2 := listener.Accept()
-		if err2 != nil {
+		conn, err := listener.Accept()
+		if err != nil {
 			return
 		}
 		// 处理连接
@@ -252,247 +750,1139 @@ func Domain() {
 	}
 }
 
+// Shutdown 按依赖顺序的逆序停止所有正在运行的服务，每个服务遵循各自的停止超时。
+// 调用后守护进程不再启动新的服务（包括自动重启）。
+func Shutdown() {
+	lock.Lock()
+	shuttingDown = true
+	var running []string
+	for service, command := range mapCommand {
+		if isCommandRunning(service, command) {
+			running = append(running, service)
+		}
+	}
+	lock.Unlock()
+
+	order, _ := orderServices(running)
+	for i := len(order) - 1; i >= 0; i-- {
+		log.Printf("Stopping service: %s\n", order[i])
+		if err := Stop(order[i]); err != nil {
+			log.Printf("Failed to stop service %s: %v\n", order[i], err)
+		}
+	}
+}
+
 // handleConnection 处理到守护进程的单个客户端连接。
-// 它解析传入的命令并将其分派到适当的处理程序。
+// 它循环读取请求帧，启用认证时先校验请求携带的令牌，执行后将响应以一帧写回，
+// 直到客户端关闭连接。这样脚本可以在一个连接上依次发送多条命令。
 func handleConnection(conn net.Conn) {
 	defer func() { _ = conn.Close() }()
 
-	buf := make([]byte, 1024)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		msg, err := authenticate(string(frame))
+		if err != nil {
+			_ = writeFrame(conn, encodeResponse("", err))
+			return
+		}
+		if service, ok := strings.CutPrefix(msg, "follow:"); ok {
+			// status --follow占用整个连接推送状态变化
+			Follow(conn, service)
+			return
+		}
+		if err = writeFrame(conn, encodeResponse(handleMessage(msg))); err != nil {
+			return
+		}
+		if exitRequested.Load() {
+			// poweroff/halt已停止所有服务，响应发送后退出守护进程
+			_ = os.Remove(socketPath)
+			_ = os.Remove(statePath)
+			os.Exit(0)
+		}
+		if reexecRequested.Load() {
+			reexec()
+		}
+	}
+}
+
+// handleMessage 解析一条请求消息并返回响应文本，操作失败时返回错误。
+// 批量请求交给handleBatch，其余请求按"operation:service[:args]"格式分派，
+// 服务名称为通配符模式时对所有匹配的服务执行操作。
+func handleMessage(msg string) (string, error) {
+	if strings.HasPrefix(msg, "batch:") {
+		return handleBatch(msg)
 	}
-	msg := string(buf[:n])
-	split := strings.Split(msg, ":")
+	// 首行之后的内容作为消息体（如edit的drop-in内容），附加为最后一个参数
+	header, body, hasBody := strings.Cut(msg, "\n")
+	split := strings.Split(header, ":")
 	if len(split) < 2 {
-		return
+		return "", errors.New("invalid request")
+	}
+	args := split[2:]
+	if hasBody {
+		args = append(args, body)
+	}
+	op, service := split[0], strings.ReplaceAll(split[1], ".service", "")
+	if globOps[op] && isGlob(service) {
+		return dispatchGlob(op, service, args)
+	}
+	return dispatch(op, service, args)
+}
+
+// dispatch 将单个操作分派到适当的处理程序。
+// 成功时返回处理程序的输出，没有输出的操作返回"success"。
+func dispatch(op, service string, args []string) (string, error) {
+	if (op == "start" || op == "stop" || op == "restart") && len(args) > 0 && args[0] == "no-block" {
+		log.Printf("%s --no-block: %s\n", op, service)
+		go func() {
+			if _, err := dispatch(op, service, args[1:]); err != nil {
+				log.Printf("Failed to %s service %s: %v\n", op, service, err)
+			}
+		}()
+		return "queued", nil
+	}
+	if jobOps[op] {
+		j := addJob(service, op)
+		defer removeJob(j)
 	}
-	split[1] = strings.ReplaceAll(split[1], ".service", "")
-	switch split[0] {
+
+	if (op == "start" || op == "stop" || op == "restart") && len(args) > 0 && args[0] == "dry-run" {
+		log.Printf("%s --dry-run: %s\n", op, service)
+		return DryRun(op, service)
+	}
+
+	var err error
+	switch op {
 	case "enable":
-		log.Println("enable:", split[1])
-		err = Enable(split[1])
+		log.Println("enable:", service)
+		err = Enable(service, len(args) > 0 && args[0] == "force")
 	case "disable":
-		log.Println("disable:", split[1])
-		err = Disable(split[1])
+		log.Println("disable:", service)
+		err = Disable(service)
 	case "start":
-		log.Println("start:", split[1])
-		err = Start(split[1], 5)
+		log.Println("start:", service)
+		if name, ok := strings.CutSuffix(service, ".socket"); ok {
+			err = StartSocket(name)
+			break
+		}
+		if name, ok := strings.CutSuffix(service, ".timer"); ok {
+			err = StartTimer(name)
+			break
+		}
+		if err = Start(service); errors.Is(err, errAlreadyRunning) {
+			return "already running", nil
+		} else if errors.Is(err, errConditionFailed) {
+			return err.Error(), nil
+		}
 	case "stop":
-		log.Println("stop:", split[1])
-		err = Stop(split[1])
+		log.Println("stop:", service)
+		if name, ok := strings.CutSuffix(service, ".socket"); ok {
+			err = StopSocket(name)
+			break
+		}
+		if name, ok := strings.CutSuffix(service, ".timer"); ok {
+			err = StopTimer(name)
+			break
+		}
+		err = Stop(service)
+	case "restart":
+		log.Println("restart:", service)
+		err = Restart(service)
+	case "reload":
+		log.Println("reload:", service)
+		err = Reload(service)
+	case "try-restart":
+		log.Println("try-restart:", service)
+		restarted, err2 := TryRestart(service)
+		if err2 != nil {
+			return "", err2
+		}
+		if !restarted {
+			return "service is not running, nothing to do", nil
+		}
+	case "reload-or-restart":
+		log.Println("reload-or-restart:", service)
+		action, err2 := ReloadOrRestart(service)
+		if err2 != nil {
+			return "", fmt.Errorf("%s failed: %w", action, err2)
+		}
+		return action + ": success", nil
 	case "status":
-		log.Println("status:", split[1])
-		res, err2 := Status(split[1])
+		log.Println("status:", service)
+		return Status(service)
+	case "show":
+		log.Println("show:", service)
+		return Show(service, args)
+	case "kill":
+		log.Println("kill:", service)
+		sigName := "SIGTERM"
+		if len(args) > 0 {
+			sigName = args[0]
+		}
+		sig, err2 := parseSignal(sigName)
 		if err2 != nil {
-			err = err2
-		} else {
-			_, _ = conn.Write([]byte(res))
-			return
+			return "", err2
+		}
+		err = Kill(service, sig)
+	case "freeze":
+		log.Println("freeze:", service)
+		err = Freeze(service)
+	case "thaw":
+		log.Println("thaw:", service)
+		err = Thaw(service)
+	case "edit":
+		log.Println("edit:", service)
+		if len(args) == 0 {
+			return "", errors.New("drop-in content required")
+		}
+		return Edit(service, args[len(args)-1])
+	case "logs":
+		log.Println("logs:", service)
+		n := defaultLogLines
+		if len(args) > 0 {
+			var err2 error
+			if n, err2 = strconv.Atoi(args[0]); err2 != nil || n <= 0 {
+				return "", fmt.Errorf("invalid number of lines: %s", args[0])
+			}
 		}
+		return Logs(service, n)
+	case "mask":
+		log.Println("mask:", service)
+		err = Mask(service)
+	case "unmask":
+		log.Println("unmask:", service)
+		err = Unmask(service)
+	case "reset-failed":
+		log.Println("reset-failed:", service)
+		err = ResetFailed(service)
+	case "list-dependencies":
+		log.Println("list-dependencies:", service)
+		return ListDependencies(service)
+	case "list-jobs":
+		log.Println("list-jobs")
+		return ListJobs(), nil
+	case "list-unit-files":
+		log.Println("list-unit-files")
+		return ListUnitFiles(), nil
+	case "list-units":
+		log.Println("list-units")
+		return ListUnits(args), nil
+	case "show-environment":
+		log.Println("show-environment")
+		return ShowEnvironment(), nil
+	case "set-environment":
+		log.Println("set-environment")
+		if len(args) == 0 {
+			return "", errors.New("environment variable required")
+		}
+		err = SetEnvironment(strings.Split(args[len(args)-1], "\n"))
+	case "unset-environment":
+		log.Println("unset-environment")
+		if len(args) == 0 {
+			return "", errors.New("environment variable required")
+		}
+		UnsetEnvironment(strings.Split(args[len(args)-1], "\n"))
+	case "daemon-reload":
+		log.Println("daemon-reload")
+		return DaemonReload(len(args) > 0 && args[0] == "clean")
+	case "daemon-reexec":
+		log.Println("daemon-reexec")
+		reexecRequested.Store(true)
+	case "isolate":
+		log.Println("isolate:", service)
+		err = Isolate(service)
+	case "get-default":
+		log.Println("get-default")
+		return bootTarget(), nil
+	case "set-default":
+		log.Println("set-default:", service)
+		err = SetDefault(service)
+	case "poweroff", "halt":
+		log.Println(op)
+		Shutdown()
+		exitRequested.Store(true)
+	case "soft-reboot":
+		log.Println("soft-reboot")
+		SoftReboot()
 	case "reboot":
 		log.Println("reboot")
 		os.Exit(0)
+	default:
+		return "", fmt.Errorf("unknown operation: %s", op)
 	}
 	if err != nil {
-		_, _ = conn.Write([]byte(err.Error()))
-		return
-	} else {
-		_, _ = conn.Write([]byte("success"))
-		return
+		return "", err
 	}
+	return "success", nil
 }
 
-// Enable 在multi-user.target.wants目录中为服务创建符号链接。
-// 这使得服务在守护进程启动时自动启动。
-func Enable(service string) error {
+// Enable 在[Install]中WantedBy=列出的每个目标的.wants目录（默认为multi-user.target.wants）中
+// 为服务创建符号链接，这使得服务在守护进程启动时自动启动。没有[Install]节的单元只有force时才能启用。
+// 以.socket或.timer结尾的名称表示socket或timer单元，启用后守护进程启动时激活它们。
+// Also=列出的单元会一并启用，Alias=中的每个别名在localUnitPath中创建指向单元文件的链接。
+func Enable(service string, force bool) error {
 	lock.Lock()
 	defer lock.Unlock()
-	path := find(service)
-	if path == "" {
-		return errors.New("no service found")
-	}
-	err := os.Symlink(path, fmt.Sprintf("%s/%s.service", enablePath, service))
-	if err != nil {
-		return err
-	}
-	return nil
+	return enableUnit(service, force, map[string]bool{})
 }
 
-// Disable 从multi-user.target.wants目录中移除服务的符号链接。
-// 这防止服务自动启动。
+// Disable 从multi-user.target.wants和WantedBy=中各目标的.wants目录中移除服务的符号链接。
+// 这防止服务自动启动。Alias=创建的链接同时被删除，Also=列出的单元一并禁用。
 func Disable(service string) error {
 	lock.Lock()
 	defer lock.Unlock()
-	err := os.Remove(fmt.Sprintf("%s/%s.service", enablePath, service))
-	if err != nil {
-		return err
-	}
-	return nil
+	return disableUnit(service, map[string]bool{})
 }
 
-// Start 基于systemd服务文件启动服务进程。
-// 它支持重启策略和失败时的自动重试。
-func Start(service string, try int) error {
+// DaemonReload 检查multi-user.target.wants目录中指向已删除单元文件的悬空符号链接，
+// 返回检查结果。clean为true时同时删除这些链接，使启用状态与实际单元文件保持一致。
+// 单元文件已被删除的服务按CollectMode=回收。
+func DaemonReload(clean bool) (string, error) {
 	lock.Lock()
 	defer lock.Unlock()
 
-	log.Printf("Starting service: %s (attempts: %d)\n", service, try)
-
-	path := find(service)
-	if path == "" {
-		log.Printf("Service file not found: %s\n", service)
-		return errors.New("no service found")
-	}
-
-	file, err := os.ReadFile(path)
+	entries, err := os.ReadDir(enablePath)
 	if err != nil {
-		log.Printf("Failed to read service file: %v\n", err)
-		return err
+		return "", err
 	}
+	var lines []string
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		link := filepath.Join(enablePath, entry.Name())
+		if _, err = os.Stat(link); !os.IsNotExist(err) {
+			continue
+		}
+		target, _ := os.Readlink(link)
+		if !clean {
+			lines = append(lines, fmt.Sprintf("Dangling symlink %s -> %s", link, target))
+			continue
+		}
+		if err = os.Remove(link); err != nil {
+			lines = append(lines, fmt.Sprintf("Failed to remove dangling symlink %s -> %s: %v", link, target, err))
+			continue
+		}
+		log.Printf("Removed dangling symlink %s -> %s\n", link, target)
+		lines = append(lines, fmt.Sprintf("Removed dangling symlink %s -> %s", link, target))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No dangling symlinks found.")
+	}
+	for _, service := range collectUnits() {
+		lines = append(lines, fmt.Sprintf("Collected unit %s.service", service))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// errAlreadyRunning 表示start的服务已在运行，与systemd一致不做任何操作
+var errAlreadyRunning = errors.New("service is already running")
+
+// Start 基于systemd服务文件启动服务进程，服务已在运行时返回errAlreadyRunning。
+// 它支持重启策略和失败时的自动重试，重试频率受StartLimitBurst/StartLimitIntervalSec限制。
+func Start(service string) error {
+	defer lockService(service)()
+	return start(service)
+}
 
-	systemdService, err := parseSystemdService(string(file))
+// start 是Start的实现，调用方必须持有服务的锁和lock。
+func start(service string) error {
+	if isCommandRunning(service, mapCommand[service]) {
+		log.Printf("Service %s is already running\n", service)
+		return errAlreadyRunning
+	}
+	return relaunch(service)
+}
+
+// relaunch 是start和restart共用的实现：先拉起Requires=和Wants=依赖并停止Conflicts=中的服务，
+// 再终止已有实例并启动新实例。调用方必须持有服务的锁和lock。
+func relaunch(service string) error {
+	log.Printf("Starting service: %s\n", service)
+
+	if shuttingDown {
+		return errors.New("daemon is shutting down")
+	}
+	if strings.HasSuffix(service, "@") {
+		return fmt.Errorf("unit %s.service is a template and cannot be started without an instance name", service)
+	}
+	if isMasked(service) {
+		log.Printf("Refusing to start masked service: %s\n", service)
+		return errMasked(service)
+	}
+	systemdService, err := loadUnit(service)
 	if err != nil {
-		log.Printf("Failed to parse service file: %v\n", err)
+		log.Printf("Failed to load service file: %v\n", err)
+		return err
+	}
+	if err = checkConditions(service, systemdService); err != nil {
+		log.Printf("Skipping service %s: %v\n", service, err)
+		return err
+	}
+	if err = checkAsserts(service, systemdService); err != nil {
+		log.Printf("Refusing to start service: %v\n", err)
+		return err
+	}
+	if err = checkStartLimit(service, systemdService); err != nil {
+		log.Printf("Refusing to start service: %v\n", err)
+		return err
+	}
+	if err = startDependencies(service, systemdService); err != nil {
+		log.Printf("Failed to start dependencies: %v\n", err)
+		return err
+	}
+	if err = stopConflicts(service, systemdService); err != nil {
+		log.Printf("Failed to resolve conflicts: %v\n", err)
 		return err
 	}
 
 	process := mapCommand[service]
-	if process != nil && process.Process != nil {
+	if isCommandRunning(service, process) {
 		log.Printf("Terminating existing service process: %s\n", service)
-		_ = process.Process.Signal(syscall.SIGTERM)
-		_ = process.Wait()
+		// 旧实例由其启动时的Wait goroutine回收，这里按KillMode终止并等待它退出，避免重复Wait
+		terminate(service, process, systemdService)
 	}
-
-	val, err := getOptions(systemdService, "Service", "ExecStart")
-	if err != nil {
-		log.Printf("Failed to get ExecStart: %v\n", err)
+	state := getState(service)
+	state.result = ""
+	state.collectMode = collectMode(systemdService)
+	err = launch(service, systemdService)
+	if errors.Is(err, errConditionFailed) {
+		// ExecCondition=不满足，与Condition*=一样跳过启动而不视为失败
+		state.failed = false
+		state.inactiveEnter = time.Now()
 		return err
 	}
-	if val == "" {
+	state.failed = err != nil
+	if err != nil {
+		emit(service, "failed", err.Error())
+	}
+	if state.result == "" {
+		// 进程未能运行并退出时，launch失败说明服务根本没有启动
+		state.result = "success"
+		if err != nil {
+			state.result = "resources"
+			state.inactiveEnter = time.Now()
+		}
+	}
+	return err
+}
+
+// launch 启动服务的新实例并将其记录到mapCommand，同时启动监控退出和自动重启的goroutine。
+// 创建运行时目录之后的任何一步失败都会删除运行时目录和cgroup，除非旧实例仍在运行（overlap重启）。
+// 调用方必须持有lock。
+func launch(service string, systemdService []*unit.UnitOption) (err error) {
+	serviceType, _ := getOptions(systemdService, "Service", "Type")
+	execStarts := getAllOptions(systemdService, "Service", "ExecStart")
+	if len(execStarts) == 0 {
 		log.Printf("ExecStart not configured: %s\n", service)
 		return errors.New("ExecStart not found")
 	}
+	if len(execStarts) > 1 && serviceType != "oneshot" {
+		log.Printf("Multiple ExecStart configured: %s\n", service)
+		return errors.New("service has more than one ExecStart= setting, which is only allowed for Type=oneshot services")
+	}
+	val := execStarts[0]
 
-	val2, _ := getOptions(systemdService, "Service", "WorkingDirectory")
-	// 解析命令
-	split := strings.Split(val, " ")
-	var cmdArgs []string
-	if len(split) > 1 {
-		for _, s := range split[1:] {
-			if strings.HasPrefix(s, "$") {
-				getenv := os.Getenv(s)
-				if getenv != "" {
-					cmdArgs = append(cmdArgs, getenv)
-				}
-			} else {
-				cmdArgs = append(cmdArgs, s)
+	// 解析执行环境，所有Exec*命令共享同一工作目录、环境变量和运行用户
+	execCtx, err := newExecContext(systemdService)
+	if err != nil {
+		log.Printf("Failed to prepare execution context: %v\n", err)
+		return err
+	}
+	if err = checkWorkingDirectory(execCtx.dir); err != nil {
+		log.Printf("Failed to start service: %v\n", err)
+		return err
+	}
+	runtimeDirs, err := createRuntimeDirectories(systemdService, execCtx)
+	if err != nil {
+		log.Printf("Failed to create runtime directory: %v\n", err)
+		return err
+	}
+	previous := mapCommand[service]
+	defer func() {
+		if err != nil && !isCommandRunning(service, previous) {
+			removeRuntimeDirectories(service, systemdService)
+			removeCgroup(service)
+		}
+	}()
+	if len(runtimeDirs) > 0 {
+		execCtx.env = append(execCtx.env, "RUNTIME_DIRECTORY="+strings.Join(runtimeDirs, ":"))
+	}
+	if err = runExecCondition(service, systemdService, execCtx); err != nil {
+		log.Printf("Skipping service %s: %v\n", service, err)
+		return err
+	}
+	if err = execCtx.runControl(service, "ExecStartPre", getAllOptions(systemdService, "Service", "ExecStartPre")); err != nil {
+		log.Printf("Failed to run ExecStartPre: %v\n", err)
+		return err
+	}
+
+	if serviceType == "oneshot" {
+		return runOneshot(service, systemdService, execCtx, execStarts)
+	}
+
+	command, err := execCtx.command(val)
+	if err != nil {
+		log.Printf("Invalid ExecStart: %v\n", err)
+		return err
+	}
+	settings, err := parseProcessSettings(systemdService)
+	if err != nil {
+		log.Printf("Failed to start service: %v\n", err)
+		return err
+	}
+
+	// Type=notify服务通过NOTIFY_SOCKET报告就绪状态
+	var notify *notifySocket
+	var ready chan struct{}
+	if serviceType == "notify" {
+		notify, err = newNotifySocket(service)
+		if err != nil {
+			log.Printf("Failed to start service: %v\n", err)
+			return err
+		}
+		ready = notify.ready
+		command.Env = append(command.Env, "NOTIFY_SOCKET="+notify.name)
+		if timeout := watchdogTimeout(systemdService); timeout > 0 {
+			command.Env = append(command.Env, watchdogEnv(timeout))
+		}
+	}
+	if files := getState(service).listenFiles; len(files) > 0 {
+		if err = socketActivated(command, files); err != nil {
+			if notify != nil {
+				notify.close()
 			}
+			log.Printf("Failed to start service: %v\n", err)
+			return err
+		}
+	}
+
+	stdout, stderr, err := openOutput(service, systemdService)
+	if err != nil {
+		if notify != nil {
+			notify.close()
 		}
+		log.Printf("Failed to start service: %v\n", err)
+		return err
 	}
-	command := exec.Command(split[0], cmdArgs...)
-	// 设置工作目录
-	if val2 != "" {
-		command.Dir = val2
+	if stdout != nil {
+		command.Stdout = stdout
 	} else {
-		command.Dir = "/root"
+		command.Stdout = os.Stdout
 	}
+	if stderr != nil {
+		command.Stderr = stderr
+	} else {
+		command.Stderr = os.Stderr
+	}
+
+	release := placeInCgroup(service, command, settings.cgroupLimits, settings.ipFilter)
 	mapCommand[service] = command
 	log.Printf("Executing command: %s\n", command.String())
-	command.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true,
-	}
-	err = command.Start()
+	err = startWaited(command)
+	// 子进程已持有输出文件和cgroup的副本
+	closeOutput(stdout, stderr)
+	release()
 	if err != nil {
+		// 未启动的命令没有进程，不能留在mapCommand中
+		if mapCommand[service] == command {
+			delete(mapCommand, service)
+		}
+		if notify != nil {
+			notify.close()
+		}
 		log.Printf("Failed to start service: %v\n", err)
 		return err
 	}
+	settings.apply(service, command.Process.Pid)
+	if notify != nil {
+		go notify.serve(service, command.Process.Pid, notifyAccess(systemdService))
+	}
 
-	log.Printf("Service started successfully: %s (PID: %d)\n", service, command.Process.Pid)
+	getState(service).serviceType = serviceType
+	getState(service).notify = notify
+	getState(service).pidFile, _ = getOptions(systemdService, "Service", "PIDFile")
+	getState(service).frozen = false
+	getState(service).health = ""
 
+	exited := make(chan struct{})
 	go func() {
 		_ = command.Wait()
-		exitCode := command.ProcessState.ExitCode()
-		log.Printf("Service exited: %s (exit code: %d)\n", service, exitCode)
+		doneWaiting(command)
+		close(exited)
+		if notify != nil {
+			notify.close()
+		}
+		status, ok := waitStatus(command)
+		if prefix, _ := splitExecPrefix(val); prefix.ignoreFailure && ok && status.Exited() {
+			// 带"-"前缀的ExecStart以非0退出码退出时按成功处理
+			status = 0
+		}
 
-		val, _ = getOptions(systemdService, "Service", "Restart")
-		if val == "always" && mapCommand[service] == nil {
-			log.Printf("Service %s configured for always restart, but service has been removed\n", service)
+		lock.Lock()
+		if mapCommand[service] != command {
+			// 该实例已被停止或被新实例替换，不再处理其退出
+			lock.Unlock()
+			log.Printf("Service %s %s\n", service, describeExit(status, ok))
+			return
+		}
+		if serviceType == "forking" && command.ProcessState.ExitCode() == 0 {
+			// forking服务的启动进程正常退出，守护进程已在后台运行
+			lock.Unlock()
+			log.Printf("Forking service %s launcher exited, service running in background\n", service)
 			return
 		}
-		if val == "on-failure" && exitCode == 0 {
-			log.Printf("Service %s exited normally, no restart needed\n", service)
+		if state := getState(service); state.starting || state.stopping == command {
+			// 就绪之前退出由launch报告为启动失败，终止过程中退出是预期的
+			lock.Unlock()
+			log.Printf("Service %s %s\n", service, describeExit(status, ok))
 			return
 		}
+		log.Printf("Service %s %s\n", service, describeExit(status, ok))
+		state := getState(service)
+		state.inactiveEnter = time.Now()
+		state.exitStatus, state.exitKnown = status, ok
+		success := parseSuccessExitStatus(systemdService)
+		state.failed = !cleanExit(status, ok, success)
+		state.result = exitResult(status, ok, success)
+		watchdog := notify != nil && notify.watchdogFired.Load()
+		if watchdog {
+			state.failed, state.result = true, "watchdog"
+		}
+		if state.failed {
+			emit(service, "failed", "Result: "+state.result)
+		} else {
+			emit(service, "exited", describeExit(status, ok))
+		}
+		lock.Unlock()
 
-		time.Sleep(time.Second * 5)
-		if try > 0 {
-			log.Printf("Attempting to restart service: %s (remaining attempts: %d)\n", service, try-1)
-			err = Start(service, try-1)
-			if err != nil {
-				log.Printf("Failed to restart service: %v\n", err)
+		restartAfterExit(service, command, systemdService, status, ok, watchdog)
+	}()
+
+	// forking服务在启动进程退出后、notify服务在收到READY=1后才算就绪，需在TimeoutStartSec内完成
+	// 等待期间释放lock，其他服务的操作和查询不必等待这个服务就绪
+	if serviceType == "forking" || serviceType == "notify" {
+		state := getState(service)
+		state.starting = true
+		timeout := timeoutOption(systemdService, "TimeoutStartSec", defaultTimeoutStartSec)
+		unlocked(func() { err = waitReady(service, command, exited, ready, timeout) })
+		state.starting = false
+		if err == nil && mapCommand[service] != command {
+			err = fmt.Errorf("service %s was stopped before it became ready", service)
+		}
+		if err != nil {
+			if mapCommand[service] == command {
+				delete(mapCommand, service)
 			}
+			log.Printf("Failed to start service: %v\n", err)
+			// 进程已被杀死，等它被回收后cgroup才能删除
+			unlocked(func() { <-exited })
+			return err
 		}
-	}()
+	}
+
+	if path := getState(service).pidFile; path != "" && pidFilePID(service) == 0 {
+		log.Printf("PID file %s of service %s is missing or stale, tracking PID %d instead\n", path, service, command.Process.Pid)
+	}
+	log.Printf("Service started successfully: %s (PID: %d)\n", service, mainPID(service, command))
+	emit(service, "started", fmt.Sprintf("PID: %d", mainPID(service, command)))
+	getState(service).activeEnter = time.Now()
+	if timeout := watchdogTimeout(systemdService); notify != nil && timeout > 0 {
+		go notify.watchdog(service, command.Process.Pid, timeout, abortTimeout(systemdService), exited)
+	}
+	if hc := parseHealthCheck(service, systemdService); hc != nil {
+		go hc.monitorHealth(service, command, execCtx, exited)
+	}
+
+	env := "MAINPID=" + strconv.Itoa(mainPID(service, command))
+	if err = execCtx.runControl(service, "ExecStartPost", getAllOptions(systemdService, "Service", "ExecStartPost"), env); err != nil {
+		log.Printf("Failed to run ExecStartPost: %v\n", err)
+	}
+
+	return nil
+}
+
+// waitReady 等待服务就绪。ready为nil时按forking语义处理：启动进程以0退出表示就绪；
+// 否则等待ready被关闭（收到READY=1），在此之前进程退出视为失败。
+// 启动失败或超过timeout仍未就绪时杀死整个进程组。
+func waitReady(service string, command *exec.Cmd, exited <-chan struct{}, ready <-chan struct{}, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout != timeSpanInfinity {
+		expired = time.After(timeout)
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-exited:
+		code := command.ProcessState.ExitCode()
+		if ready == nil && code == 0 {
+			return nil
+		}
+		// 清理启动进程可能遗留的子进程
+		_ = signalProcess(command.Process.Pid, syscall.SIGKILL, true)
+		if ready != nil {
+			return fmt.Errorf("service %s exited with code %d before signaling readiness", service, code)
+		}
+		return fmt.Errorf("service %s launcher exited with code %d", service, code)
+	case <-expired:
+		_ = signalProcess(command.Process.Pid, syscall.SIGKILL, true)
+		return fmt.Errorf("start of service %s timed out after %v", service, timeout)
+	}
+}
+
+// Restart 重启服务。当单元设置了X-RestartMode=overlap且服务正在运行时，
+// 先启动新实例，待其就绪后再停止旧实例，避免重启期间服务中断。
+// 新实例未能就绪时将其终止并保留旧实例。
+func Restart(service string) error {
+	defer lockService(service)()
+	return restart(service)
+}
+
+// TryRestart 仅在服务正在运行时重启它，返回是否执行了重启。服务未运行时不做任何操作。
+func TryRestart(service string) (bool, error) {
+	defer lockService(service)()
+
+	if find(service) == "" {
+		return false, errors.New("no service found")
+	}
+	if !isCommandRunning(service, mapCommand[service]) {
+		log.Printf("Service %s is not running, skipping try-restart\n", service)
+		return false, nil
+	}
+	return true, restart(service)
+}
+
+// ReloadOrRestart 在服务支持ExecReload且正在运行时重新加载，否则重启服务。
+// 返回实际执行的操作（reload或restart）。
+func ReloadOrRestart(service string) (string, error) {
+	defer lockService(service)()
+
+	opts, err := loadUnit(service)
+	if err != nil {
+		return "", err
+	}
+	if val, _ := getOptions(opts, "Service", "ExecReload"); val != "" && isCommandRunning(service, mapCommand[service]) {
+		return "reload", reload(service)
+	}
+	return "restart", restart(service)
+}
 
+// restart 是Restart的实现，调用方必须持有lock。
+func restart(service string) error {
+	opts, err := loadUnit(service)
+	if err != nil {
+		return err
+	}
+	// NRestarts只统计成功的重启
+	old := mapCommand[service]
+	mode, _ := getOptions(opts, "Service", "X-RestartMode")
+	if mode != "overlap" || !isCommandRunning(service, old) {
+		if err = relaunch(service); err != nil {
+			return err
+		}
+		getState(service).restarts++
+		return nil
+	}
+
+	grace := time.Second
+	if val, _ := getOptions(opts, "Service", "X-RestartOverlapSec"); val != "" {
+		if d, err2 := parseTimeSpan(val); err2 == nil {
+			grace = d
+		}
+	}
+
+	log.Printf("Overlapping restart of %s: starting new instance before stopping PID %d\n", service, old.Process.Pid)
+	if err = launch(service, opts); err != nil {
+		mapCommand[service] = old
+		return err
+	}
+	command := mapCommand[service]
+	// notify服务在launch返回时已确认就绪，其余类型等待一段宽限期，等待期间释放lock
+	if mapState[service].notify == nil {
+		unlocked(func() { time.Sleep(grace) })
+	}
+	if !isProcessRunning(command.Process.Pid) {
+		mapCommand[service] = old
+		terminate(service, command, opts)
+		return errors.New("new instance did not become ready, keeping the old instance")
+	}
+	terminate(service, old, opts)
+	getState(service).restarts++
 	return nil
 }
 
 // Stop 优雅地终止正在运行的服务进程。
-// 它首先发送SIGTERM，如果进程在5秒内没有退出则发送SIGKILL。
+// 它首先发送KillSignal（默认SIGTERM），如果进程在TimeoutStopSec（默认5秒）内没有退出则发送SIGKILL。
+// KillMode决定信号发送给整个进程组还是仅发送给主进程。
 func Stop(service string) error {
-	lock.Lock()
-	defer lock.Unlock()
+	defer lockService(service)()
+	return stop(service)
+}
 
+// stop 是Stop的实现，调用方必须持有lock。
+func stop(service string) error {
 	command := mapCommand[service]
 	if command == nil {
 		return errors.New("service is not run")
 	}
-	// 1. 尝试正常终止（SIGTERM）
-	err := command.Process.Signal(syscall.SIGTERM)
-	if err != nil {
-		log.Printf("Failed to send SIGTERM: %v\n", err)
+
+	// ExecStop运行期间会释放lock，主进程在此期间退出是预期的，不触发自动重启
+	state := getState(service)
+	state.stopping = command
+	defer func() { state.stopping = nil }()
+
+	opts, err := loadUnit(service)
+	if err == nil {
+		// 先执行ExecStop，剩余进程再由KillSignal处理
+		if execCtx, err2 := newExecContext(opts); err2 != nil {
+			log.Printf("Failed to prepare execution context: %v\n", err2)
+		} else {
+			env := "MAINPID=" + strconv.Itoa(mainPID(service, command))
+			if err2 = execCtx.runControl(service, "ExecStop", getAllOptions(opts, "Service", "ExecStop"), env); err2 != nil {
+				log.Printf("Failed to run ExecStop: %v\n", err2)
+			}
+		}
+	}
+	// 已经退出的实例的PID可能已被其他进程复用，不能再向它发送信号
+	if isCommandRunning(service, command) {
+		terminate(service, command, opts)
+	} else {
+		log.Printf("Service %s has already exited, nothing to terminate\n", service)
 	}
+	removeRuntimeDirectories(service, opts)
+	removeCgroup(service)
 
-	// 2. 等待进程退出（最多 5 秒）
-	done := make(chan error, 1)
-	go func() {
-		_, err = command.Process.Wait() // 回收子进程，避免僵尸进程
-		done <- err
-	}()
+	// 从 map 中移除 PID
+	delete(mapCommand, service)
+	if state.pidFile != "" && pidFilePID(service) == 0 {
+		// 守护进程通常在退出时自行删除PID文件，未删除时清理掉过期的文件
+		_ = os.Remove(state.pidFile)
+	}
+	state.pidFile = ""
+	state.inactiveEnter = time.Now()
+	state.failed = false
+	state.result = "success"
+	state.autoRestarts = 0
+	emit(service, "stopped", "")
+	collectUnit(service)
+	return nil
+}
 
-	select {
-	case <-time.After(5 * time.Second):
-		// 3. 超时后强制终止（SIGKILL）
-		log.Println("The process did not exit normally, forcing termination...")
-		err = command.Process.Signal(syscall.SIGKILL)
+// terminate 按照单元的KillSignal、KillMode和TimeoutStopSec终止一个服务实例，
+// 超时后发送SIGKILL。opts为nil时使用默认设置。
+func terminate(service string, command *exec.Cmd, opts []*unit.UnitOption) {
+	killSignal := syscall.SIGTERM
+	killMode := "control-group"
+	if val, _ := getOptions(opts, "Service", "KillSignal"); val != "" {
+		sig, err := parseSignal(val)
 		if err != nil {
-			log.Printf("Failed to send SIGKILL: %v\n", err)
+			log.Printf("Invalid KillSignal for %s: %v\n", service, err)
+		} else {
+			killSignal = sig
 		}
-	case err = <-done:
+	}
+	if val, _ := getOptions(opts, "Service", "KillMode"); val != "" {
+		killMode = val
+	}
+	timeout := timeoutOption(opts, "TimeoutStopSec", defaultTimeoutStopSec)
+	// 设置了PIDFile=时向其中的进程发送信号，forking服务的守护进程通常不在启动进程的进程组中
+	pid := mainPID(service, command)
+
+	// 1. 尝试正常终止（KillSignal）
+	if killMode != "none" {
+		err := signalProcess(pid, killSignal, killMode == "control-group")
 		if err != nil {
-			log.Printf("Failed waiting for process exit: %v\n", err)
+			log.Printf("Failed to send %v: %v\n", killSignal, err)
 		}
 	}
-	// 4. 从 map 中移除 PID
-	delete(mapCommand, service)
-	return nil
+	// 被冻结的进程收到SIGCONT后才会处理KillSignal
+	if isFrozen(service) {
+		if err := thaw(service, command); err != nil {
+			log.Printf("Failed to thaw %s: %v\n", service, err)
+		}
+	}
+
+	// 2. 等待进程退出（最多 TimeoutStopSec，infinity 表示无限等待）
+	// 子进程由启动时的Wait goroutine回收，这里只轮询其是否退出。等待期间释放lock
+	state := getState(service)
+	forking := state.serviceType == "forking"
+	state.stopping = command
+	defer func() { state.stopping = nil }()
+	unlocked(func() { waitExit(service, command, pid, forking, killSignal, killMode, timeout) })
+}
+
+// waitExit 等待被终止的服务实例退出，超过timeout后发送SIGKILL。不需要持有lock。
+func waitExit(service string, command *exec.Cmd, pid int, forking bool, killSignal syscall.Signal, killMode string, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		for isAlive(command, forking) || (pid != command.Process.Pid && isProcessRunning(pid)) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		close(done)
+	}()
+
+	var expired <-chan time.Time
+	if timeout != timeSpanInfinity {
+		expired = time.After(timeout)
+	}
+	// 超过警告阈值仍未退出时记录一次警告，便于排查卡住的停止过程
+	warning := time.After(stopWarningThreshold(timeout))
+	for {
+		select {
+		case <-warning:
+			if killMode != "none" {
+				log.Printf("Service %s not responding to %v after %v\n", service, signalName(killSignal), stopWarningThreshold(timeout))
+				emit(service, "not-responding", fmt.Sprintf("%v after %v", signalName(killSignal), stopWarningThreshold(timeout)))
+			}
+			warning = nil
+			continue
+		case <-expired:
+			// 3. 超时后强制终止（SIGKILL），mixed模式下发送给整个进程组
+			if killMode == "none" {
+				return
+			}
+			log.Println("The process did not exit normally, forcing termination...")
+			err := signalProcess(pid, syscall.SIGKILL, killMode != "process")
+			if pid != command.Process.Pid {
+				_ = signalProcess(command.Process.Pid, syscall.SIGKILL, killMode != "process")
+			}
+			if err != nil {
+				log.Printf("Failed to send SIGKILL: %v\n", err)
+			}
+		case <-done:
+			// mixed模式下主进程退出后，进程组中剩余的进程直接收到SIGKILL
+			if killMode == "mixed" && ownsProcessGroup(command.Process.Pid) {
+				_ = syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+			}
+		}
+		return
+	}
+}
+
+// stopWarningThreshold 返回停止过程中发出"未响应"警告的等待时间，为停止超时的一半。
+// 超时为infinity时使用默认停止超时。
+func stopWarningThreshold(timeout time.Duration) time.Duration {
+	if timeout == timeSpanInfinity {
+		return defaultTimeoutStopSec
+	}
+	return timeout / 2
+}
+
+// signalProcess 向进程发送信号。group为true时发送给以pid为组长的整个进程组，
+// 由于服务以Setsid启动，其进程组ID等于主进程PID；接管的进程不一定是组长，此时只发送给它自己。
+// 组长已退出（如forking服务的启动进程）时，只有ownsProcessGroup确认进程组仍属于服务才发送给整个进程组。
+func signalProcess(pid int, sig syscall.Signal, group bool) error {
+	if group {
+		if pgid, err := syscall.Getpgid(pid); (err == nil && pgid == pid) || ownsProcessGroup(pid) {
+			return syscall.Kill(-pid, sig)
+		}
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// ownsProcessGroup 判断组长pid已退出后，ID为pid的进程组是否仍是服务创建的那一个：
+// pid不再属于任何进程，而进程组中还有进程。进程组存在期间内核不会把它的ID分配给新进程，
+// 因此这样的进程组是组长退出前就存在的；pid已被其他进程使用时则无法确认，返回false。
+func ownsProcessGroup(pid int) bool {
+	if _, err := syscall.Getpgid(pid); !errors.Is(err, syscall.ESRCH) {
+		return false
+	}
+	return syscall.Kill(-pid, 0) == nil
 }
 
-// Status 检查服务是否正在运行。
-// 根据进程状态返回"running"或"exited"。
+// Reload 执行服务的ExecReload指令，在不停止进程的情况下重新加载配置。
+// 指令中的$MAINPID会被替换为服务主进程的PID。
+func Reload(service string) error {
+	defer lockService(service)()
+	return reload(service)
+}
+
+// reload 是Reload的实现，调用方必须持有lock。
+func reload(service string) error {
+	opts, err := loadUnit(service)
+	if err != nil {
+		return err
+	}
+	val, _ := getOptions(opts, "Service", "ExecReload")
+	if val == "" {
+		return fmt.Errorf("job type reload is not applicable for unit %s.service", service)
+	}
+	command := mapCommand[service]
+	if !isCommandRunning(service, command) {
+		return errors.New("service is not run")
+	}
+
+	execCtx, err := newExecContext(opts)
+	if err != nil {
+		return err
+	}
+	return execCtx.runControl(service, "ExecReload", []string{val}, "MAINPID="+strconv.Itoa(mainPID(service, command)))
+}
+
+// Kill 向正在运行的服务进程组发送指定信号。
+// 常用于让守护进程通过SIGHUP重新加载配置。
+func Kill(service string, sig syscall.Signal) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	command := mapCommand[service]
+	if !isCommandRunning(service, command) {
+		return errors.New("service is not run")
+	}
+	pid := mainPID(service, command)
+	log.Printf("Sending signal %v to service %s (PID: %d)\n", sig, service, pid)
+	return signalProcess(pid, sig, true)
+}
+
+// Status 返回类似systemctl status的服务状态：单元描述、LoadState（loaded/not-found/masked）、
+// ActiveState（active/inactive/failed/activating/deactivating）、SubState（running/exited/dead等）、
+// 主进程PID和进程组的资源用量（进程数、常驻内存、CPU时间），随后是以"键=值"形式列出的属性和最近的日志。
 func Status(service string) (string, error) {
 	lock.Lock()
 	defer lock.Unlock()
 	path := find(service)
-	if path == "" {
+	state := mapState[service]
+	command := mapCommand[service]
+	if path == "" && state == nil && command == nil && !isMasked(service) {
 		return "", errors.New("no service found")
 	}
-	command := mapCommand[service]
-	if command == nil || command.Process == nil || !isProcessRunning(command.Process.Pid) {
-		return "exited", nil
+	opts, _ := loadUnit(service)
+	loadState := unitLoadState(service)
+	activeState, subState := unitActiveState(service, opts)
+	running := isCommandRunning(service, command)
+
+	var b strings.Builder
+	b.WriteString(service + ".service")
+	if description, _ := getOptions(opts, "Unit", "Description"); description != "" {
+		b.WriteString(" - " + description)
+	}
+	loaded := loadState
+	if loadState == "loaded" {
+		enabled := "disabled"
+		if _, err := os.Lstat(fmt.Sprintf("%s/%s.service", enablePath, service)); err == nil {
+			enabled = "enabled"
+		}
+		loaded += fmt.Sprintf(" (%s; %s)", path, enabled)
+	}
+	_, _ = fmt.Fprintf(&b, "\n   Loaded: %s", loaded)
+	if activeState == "failed" {
+		_, _ = fmt.Fprintf(&b, "\n   Active: failed (Result: %s)", state.result)
+	} else {
+		_, _ = fmt.Fprintf(&b, "\n   Active: %s (%s)", activeState, subState)
+		if running && isFrozen(service) {
+			b.WriteString(" (frozen)")
+		}
+	}
+	if state != nil {
+		switch {
+		case activeState == "active" && !state.activeEnter.IsZero():
+			b.WriteString(" since " + formatTimestamp(state.activeEnter))
+		case activeState != "active" && !state.inactiveEnter.IsZero():
+			b.WriteString(" since " + formatTimestamp(state.inactiveEnter))
+		}
+	}
+	var usage resourceUsage
+	if running {
+		pid := mainPID(service, command)
+		usage = serviceUsage(pid)
+		_, _ = fmt.Fprintf(&b, "\n Main PID: %d", pid)
+		if usage.tasks > 0 {
+			_, _ = fmt.Fprintf(&b, "\n    Tasks: %d\n   Memory: %s\n      CPU: %v", usage.tasks, formatBytes(usage.rss), usage.cpu.Truncate(time.Millisecond))
+		}
+	}
+	if state != nil && state.notify != nil && state.notify.statusText() != "" {
+		_, _ = fmt.Fprintf(&b, "\n   Status: %q", state.notify.statusText())
+	}
+	if state != nil && state.condition != "" && !running {
+		b.WriteString("\nCondition: start condition unmet: " + state.condition)
+	}
+	if state != nil && state.assert != "" && !running {
+		b.WriteString("\n   Assert: start assertion failed: " + state.assert)
+	}
+	if state != nil && state.crashLoop != "" {
+		b.WriteString("\n   Notice: crash loop detected (" + state.crashLoop + ")")
+		if state.crashLoopDisabled {
+			b.WriteString(", unit disabled")
+		}
+	}
+
+	b.WriteString("\n")
+	for _, property := range serviceProperties(service, opts, usage) {
+		b.WriteString("\n" + property)
+	}
+
+	if opts != nil {
+		if logs, err := Logs(service, defaultLogLines); err == nil && logs != "" {
+			b.WriteString("\n\n" + logs)
+		}
 	}
-	return "running", nil
+	return b.String(), nil
+}
+
+// unitActiveState 返回服务的ActiveState和SubState。调用方必须持有lock。
+func unitActiveState(service string, opts []*unit.UnitOption) (active, sub string) {
+	state := mapState[service]
+	running := isCommandRunning(service, mapCommand[service])
+	switch op := pendingJob(service); {
+	case running && op == "stop":
+		return "deactivating", "stop"
+	case running && state.starting:
+		return "activating", "start"
+	case !running && (op == "start" || op == "restart" || op == "reload-or-restart"):
+		return "activating", "start"
+	}
+	switch {
+	case running && state != nil && state.health != "":
+		// 配置了X-HealthCheck=时以最近的检查结果作为SubState
+		return "active", state.health
+	case running:
+		return "active", "running"
+	case state != nil && (state.failed || state.startLimitHit):
+		return "failed", "failed"
+	case state != nil && state.serviceType == "oneshot" && !state.activeEnter.IsZero() && remainAfterExit(opts):
+		return "active", "exited"
+	default:
+		return "inactive", "dead"
+	}
+}
+
+// remainAfterExit 判断单元是否设置了RemainAfterExit=yes，即oneshot服务执行完毕后仍视为active。
+func remainAfterExit(opts []*unit.UnitOption) bool {
+	val, _ := getOptions(opts, "Service", "RemainAfterExit")
+	switch val {
+	case "yes", "true", "on", "1":
+		return true
+	}
+	return false
+}
+
+// formatTimestamp 以systemd风格格式化时间戳。
+func formatTimestamp(t time.Time) string {
+	return t.Format("Mon 2006-01-02 15:04:05 MST")
+}
+
+// isCommandRunning 判断服务实例是否仍在运行。调用方必须持有lock。
+// forking服务的启动进程退出后，以其进程组中是否仍有存活进程为准；
+// 设置了PIDFile=时，其中记录的进程存活也视为运行中。
+func isCommandRunning(service string, command *exec.Cmd) bool {
+	if command == nil {
+		return false
+	}
+	state := mapState[service]
+	return isAlive(command, state != nil && state.serviceType == "forking") || pidFilePID(service) > 0
+}
+
+// isAlive 判断进程实例是否存活，group为true时进程组中任一进程存活即可。
+func isAlive(command *exec.Cmd, group bool) bool {
+	if command == nil || command.Process == nil {
+		return false
+	}
+	if isProcessRunning(command.Process.Pid) {
+		return true
+	}
+	return group && syscall.Kill(-command.Process.Pid, 0) == nil
 }
 
 // isProcessRunning 检查给定PID的进程是否仍然存活。
@@ -505,3 +1895,149 @@ func isProcessRunning(pid int) bool {
 	err = p.Signal(syscall.Signal(0))
 	return err == nil
 }
+
+// signalNames 将Linux上常见的信号名称（不含SIG前缀）映射到对应的信号值。
+// 每个信号只有一个名称（不包含IOT、CLD等别名），以便signalName的结果唯一。
+var signalNames = map[string]syscall.Signal{
+	"HUP":    syscall.SIGHUP,
+	"INT":    syscall.SIGINT,
+	"QUIT":   syscall.SIGQUIT,
+	"ILL":    syscall.SIGILL,
+	"TRAP":   syscall.SIGTRAP,
+	"ABRT":   syscall.SIGABRT,
+	"BUS":    syscall.SIGBUS,
+	"FPE":    syscall.SIGFPE,
+	"KILL":   syscall.SIGKILL,
+	"USR1":   syscall.SIGUSR1,
+	"SEGV":   syscall.SIGSEGV,
+	"USR2":   syscall.SIGUSR2,
+	"PIPE":   syscall.SIGPIPE,
+	"ALRM":   syscall.SIGALRM,
+	"TERM":   syscall.SIGTERM,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"STOP":   syscall.SIGSTOP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+	"VTALRM": syscall.SIGVTALRM,
+	"PROF":   syscall.SIGPROF,
+	"WINCH":  syscall.SIGWINCH,
+	"IO":     syscall.SIGIO,
+	"PWR":    syscall.SIGPWR,
+	"SYS":    syscall.SIGSYS,
+}
+
+// maxSignal 是Linux上最大的信号编号（SIGRTMAX）
+const maxSignal = 64
+
+// signalName 返回信号的SIG前缀名称（如SIGTERM），未知信号返回其数值描述。
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return "SIG" + name
+		}
+	}
+	return sig.String()
+}
+
+// timeSpanInfinity 表示"infinity"时间跨度，即永不超时
+const timeSpanInfinity = time.Duration(math.MaxInt64)
+
+// defaultTimeoutStartSec 是未配置TimeoutStartSec时等待服务就绪的超时，与systemd一致
+const defaultTimeoutStartSec = 90 * time.Second
+
+// defaultTimeoutStopSec 是未配置TimeoutStopSec时的停止超时，比systemd的90秒更短以适应容器场景
+const defaultTimeoutStopSec = 5 * time.Second
+
+// timeSpanUnits 是systemd时间跨度支持的单位，与systemd.time(7)一致，月和年按平均长度计算
+var timeSpanUnits = map[string]time.Duration{
+	"us": time.Microsecond, "usec": time.Microsecond, "µs": time.Microsecond,
+	"ms": time.Millisecond, "msec": time.Millisecond,
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"M": 2629800 * time.Second, "month": 2629800 * time.Second, "months": 2629800 * time.Second,
+	"y": 31557600 * time.Second, "year": 31557600 * time.Second, "years": 31557600 * time.Second,
+}
+
+// parseTimeSpan 按systemd.time(7)的规则解析时间跨度：由若干"数字[单位]"组成，各部分之间可以有空格，
+// 如"5min 20s"、"1h30m"、"100ms"、"1.5s"。不带单位的数字按秒处理，"infinity"返回timeSpanInfinity。
+func parseTimeSpan(val string) (time.Duration, error) {
+	val = strings.TrimSpace(val)
+	if val == "infinity" {
+		return timeSpanInfinity, nil
+	}
+	if val == "" {
+		return 0, errors.New("invalid time span: empty value")
+	}
+	var total time.Duration
+	for rest := val; rest != ""; rest = strings.TrimLeft(rest, " \t") {
+		i := strings.IndexFunc(rest, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i < 0 {
+			i = len(rest)
+		}
+		number, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil || number < 0 {
+			return 0, fmt.Errorf("invalid time span: %s", val)
+		}
+		rest = strings.TrimLeft(rest[i:], " \t")
+		j := strings.IndexFunc(rest, func(r rune) bool { return (r >= '0' && r <= '9') || r == '.' || r == ' ' || r == '\t' })
+		if j < 0 {
+			j = len(rest)
+		}
+		unit := time.Second
+		if j > 0 {
+			var ok bool
+			if unit, ok = timeSpanUnits[rest[:j]]; !ok {
+				return 0, fmt.Errorf("invalid time span: %s: unknown unit %q", val, rest[:j])
+			}
+		}
+		part := number * float64(unit)
+		if part >= float64(timeSpanInfinity-total) {
+			return 0, fmt.Errorf("invalid time span: %s: out of range", val)
+		}
+		total += time.Duration(part)
+		rest = rest[j:]
+	}
+	return total, nil
+}
+
+// timeoutOption 读取[Service]中的超时选项，未设置时回退到TimeoutSec，再回退到默认值。
+func timeoutOption(opts []*unit.UnitOption, name string, def time.Duration) time.Duration {
+	val, _ := getOptions(opts, "Service", name)
+	if val == "" {
+		val, _ = getOptions(opts, "Service", "TimeoutSec")
+	}
+	if val == "" {
+		return def
+	}
+	d, err := parseTimeSpan(val)
+	if err != nil {
+		log.Printf("Invalid %s: %v\n", name, err)
+		return def
+	}
+	if d == 0 {
+		// 与systemd一致，0表示禁用超时
+		return timeSpanInfinity
+	}
+	return d
+}
+
+// parseSignal 将信号名称或编号（如SIGHUP、HUP、hup、1）解析为syscall.Signal。
+// kill -s、KillSignal=等所有指定信号的地方都通过它解析。
+func parseSignal(name string) (syscall.Signal, error) {
+	key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if sig, ok := signalNames[key]; ok {
+		return sig, nil
+	}
+	if n, err := strconv.Atoi(key); err == nil && n > 0 && n <= maxSignal {
+		return syscall.Signal(n), nil
+	}
+	return 0, fmt.Errorf("unknown signal: %s", name)
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStartTwice(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "twice.service", "[Service]\nExecStart=/bin/sleep 60\n")
+
+	if res, err := dispatch("start", "twice", nil); err != nil {
+		t.Fatalf("first start: %v", err)
+	} else if res != "success" {
+		t.Fatalf("first start = %q, want success", res)
+	}
+	lock.Lock()
+	first := mapCommand["twice"].Process.Pid
+	lock.Unlock()
+
+	res, err := dispatch("start", "twice", nil)
+	if err != nil {
+		t.Fatalf("second start: %v", err)
+	}
+	if res != "already running" {
+		t.Errorf("second start = %q, want already running", res)
+	}
+	lock.Lock()
+	second := mapCommand["twice"].Process.Pid
+	lock.Unlock()
+	if first != second {
+		t.Errorf("second start replaced PID %d with %d", first, second)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"SIGTERM", syscall.SIGTERM, false},
+		{"TERM", syscall.SIGTERM, false},
+		{"term", syscall.SIGTERM, false},
+		{"sigkill", syscall.SIGKILL, false},
+		{" SIGHUP ", syscall.SIGHUP, false},
+		{"QUIT", syscall.SIGQUIT, false},
+		{"SIGUSR1", syscall.SIGUSR1, false},
+		{"USR2", syscall.SIGUSR2, false},
+		{"SIGWINCH", syscall.SIGWINCH, false},
+		{"SIGCHLD", syscall.SIGCHLD, false},
+		{"1", syscall.SIGHUP, false},
+		{"9", syscall.SIGKILL, false},
+		{"15", syscall.SIGTERM, false},
+		{"64", syscall.Signal(64), false},
+		{"0", 0, true},
+		{"65", 0, true},
+		{"-1", 0, true},
+		{"", 0, true},
+		{"SIG", 0, true},
+		{"SIGBOGUS", 0, true},
+		{"1.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSignal(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSignal(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSignal(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvokedAs(t *testing.T) {
+	tests := []struct {
+		argv0 string
+		want  string
+	}{
+		{"reboot", "reboot"},
+		{"/sbin/reboot", "reboot"},
+		{"/usr/sbin/poweroff", "poweroff"},
+		{"halt", "halt"},
+		{"systemctl", ""},
+		{"/usr/bin/systemctl", ""},
+		{"/opt/reboot-tools/systemctl", ""},
+		{"/home/halting/bin/systemctl", ""},
+		{"rebooter", ""},
+		{"./poweroff-test", ""},
+	}
+	for _, tt := range tests {
+		if got := invokedAs(tt.argv0); got != tt.want {
+			t.Errorf("invokedAs(%q) = %q, want %q", tt.argv0, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeSpan(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"5", 5 * time.Second, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"100ms", 100 * time.Millisecond, false},
+		{"250us", 250 * time.Microsecond, false},
+		{"2min", 2 * time.Minute, false},
+		{"5min 20s", 5*time.Minute + 20*time.Second, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"1h 30min 15s 500ms", time.Hour + 30*time.Minute + 15*time.Second + 500*time.Millisecond, false},
+		{"2 h", 2 * time.Hour, false},
+		{"  10s  ", 10 * time.Second, false},
+		{"1d", 24 * time.Hour, false},
+		{"1w", 7 * 24 * time.Hour, false},
+		{"3 seconds", 3 * time.Second, false},
+		{"1M", 2629800 * time.Second, false},
+		{"1y", 31557600 * time.Second, false},
+		{"0.5min", 30 * time.Second, false},
+		{"5 min 3", 5*time.Minute + 3*time.Second, false},
+		{"infinity", timeSpanInfinity, false},
+		{"", 0, true},
+		{"   ", 0, true},
+		{"5x", 0, true},
+		{"min", 0, true},
+		{"-5s", 0, true},
+		{"1..5s", 0, true},
+		{"infinite", 0, true},
+		{"300y", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeSpan(tt.val)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeSpan(%q) error = %v, wantErr %v", tt.val, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimeSpan(%q) = %v, want %v", tt.val, got, tt.want)
+		}
+	}
+}
+
+// TestActiveTimestamps 检查ActiveEnter和InactiveEnter时间戳随启动和停止更新，并显示在status中。
+func TestActiveTimestamps(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "stamped.service", "[Service]\nExecStart=/bin/sleep 60\n")
+	timestamps := func() (active, inactive time.Time) {
+		lock.Lock()
+		defer lock.Unlock()
+		return mapState["stamped"].activeEnter, mapState["stamped"].inactiveEnter
+	}
+	checkStatus := func(want string) {
+		t.Helper()
+		status, err := Status("stamped")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(status, want) {
+			t.Errorf("status does not contain %q:\n%s", want, status)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		begin := time.Now()
+		if err := Start("stamped"); err != nil {
+			t.Fatal(err)
+		}
+		active, _ := timestamps()
+		if active.Before(begin) {
+			t.Fatalf("after start %d: ActiveEnter=%v, started at %v", i+1, active, begin)
+		}
+		checkStatus("ActiveEnterTimestamp=" + formatTimestamp(active))
+
+		begin = time.Now()
+		if err := Stop("stamped"); err != nil {
+			t.Fatal(err)
+		}
+		lastActive, inactive := timestamps()
+		if !lastActive.Equal(active) || inactive.Before(begin) {
+			t.Fatalf("after stop %d: ActiveEnter=%v InactiveEnter=%v, stopped at %v", i+1, lastActive, inactive, begin)
+		}
+		checkStatus("InactiveEnterTimestamp=")
+	}
+}
+
+// processAlive 判断进程是否存在且不是僵尸进程。孤儿进程由init回收，被杀死后可能短暂处于僵尸状态。
+func processAlive(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	_, fields, _ := strings.Cut(string(data), ") ")
+	return !strings.HasPrefix(fields, "Z")
+}
+
+// TestKillMode 检查停止时KillMode对主进程派生的孙进程的影响：
+// control-group和mixed会终止孙进程，process只终止主进程。
+func TestKillMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		survives bool
+	}{
+		{"control-group", false},
+		{"mixed", false},
+		{"process", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			dir := setupTestPaths(t)
+			pidFile := filepath.Join(dir, "grandchild.pid")
+			script := filepath.Join(dir, "spawner.sh")
+			content := "#!/bin/sh\nsleep 60 &\necho $! > " + pidFile + "\nwait\n"
+			if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeUnit(t, "spawner.service", "[Service]\nKillMode="+tt.mode+"\nExecStart="+script+"\n")
+			if err := Start("spawner"); err != nil {
+				t.Fatal(err)
+			}
+			var pid int
+			waitFor(t, 5*time.Second, "grandchild PID", func() bool {
+				data, _ := os.ReadFile(pidFile)
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+				return pid > 0
+			})
+			t.Cleanup(func() { _ = syscall.Kill(pid, syscall.SIGKILL) })
+
+			if err := Stop("spawner"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.survives {
+				if !processAlive(pid) {
+					t.Errorf("grandchild %d was killed with KillMode=%s", pid, tt.mode)
+				}
+				return
+			}
+			waitFor(t, time.Second, "grandchild to exit", func() bool { return !processAlive(pid) })
+		})
+	}
+}
+
+// TestStopTimeout 检查忽略SIGTERM的服务在TimeoutStopSec之后才被SIGKILL终止。
+func TestStopTimeout(t *testing.T) {
+	dir := setupTestPaths(t)
+	script := filepath.Join(dir, "stubborn.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ntrap '' TERM\nwhile :; do sleep 0.05; done\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "stubborn.service", "[Service]\nTimeoutStopSec=500ms\nExecStart="+script+"\n")
+	if err := Start("stubborn"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	pid := mapCommand["stubborn"].Process.Pid
+	lock.Unlock()
+	// 等待shell设置好trap
+	time.Sleep(100 * time.Millisecond)
+
+	begin := time.Now()
+	if err := Stop("stubborn"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed < 500*time.Millisecond || elapsed > 3*time.Second {
+		t.Errorf("stop took %v, want about TimeoutStopSec=500ms", elapsed)
+	}
+	waitFor(t, time.Second, "stubborn service to be killed", func() bool { return !processAlive(pid) })
+}
+
+// TestSignalProcessGroup 检查组长退出后，signalProcess只在进程组仍属于服务时才向它发送信号。
+func TestSignalProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	// 组长在新的会话中派生一个同组的子进程后退出
+	leader := exec.Command("/bin/sh", "-c", "sleep 60 & echo $! > "+pidFile)
+	leader.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := leader.Run(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	t.Cleanup(func() { _ = syscall.Kill(child, syscall.SIGKILL) })
+	pid := leader.Process.Pid
+
+	if !ownsProcessGroup(pid) {
+		t.Fatalf("process group %d with a remaining member is not recognized as owned", pid)
+	}
+	if err = signalProcess(pid, syscall.SIGKILL, true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "remaining member of the group to be killed", func() bool { return !processAlive(child) })
+
+	// 组长已被回收且进程组为空时，pid可能被复用，不能再向进程组发送信号
+	alone := exec.Command("/bin/true")
+	alone.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = alone.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if ownsProcessGroup(alone.Process.Pid) {
+		t.Errorf("empty process group %d is recognized as owned", alone.Process.Pid)
+	}
+	if err = signalProcess(alone.Process.Pid, syscall.SIGKILL, true); err == nil {
+		t.Error("signalProcess succeeded for an exited leader without a process group")
+	}
+}
+
+// syncBuffer 是可以被多个goroutine同时写入的日志缓冲区。
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestStopExitedService 检查停止主进程已退出的服务时不再向它的PID发送信号，这个PID可能已被其他进程复用。
+func TestStopExitedService(t *testing.T) {
+	setupTestPaths(t)
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	writeUnit(t, "brief.service", "[Service]\nExecStart=/bin/true\n")
+	if err := Start("brief"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, "service to exit", func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return !mapState["brief"].inactiveEnter.IsZero()
+	})
+
+	if err := Stop("brief"); err != nil {
+		t.Fatal(err)
+	}
+	if out := logs.String(); !strings.Contains(out, "Service brief has already exited, nothing to terminate") {
+		t.Errorf("stop did not skip terminating the exited service, log:\n%s", out)
+	}
+}
+
+func TestDaemonReloadDanglingSymlinks(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "kept.service", "[Service]\nExecStart=/bin/sleep 60\n")
+	writeUnit(t, "ghost.service", "[Service]\nExecStart=/bin/sleep 60\n")
+	enableForTest(t, "kept")
+	enableForTest(t, "ghost")
+	if err := os.Remove(filepath.Join(localUnitPath, "ghost.service")); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(enablePath, "ghost.service")
+	target := filepath.Join(localUnitPath, "ghost.service")
+
+	report, err := DaemonReload(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Dangling symlink " + link + " -> " + target; report != want {
+		t.Errorf("daemon-reload = %q, want %q", report, want)
+	}
+	if _, err = os.Lstat(link); err != nil {
+		t.Errorf("daemon-reload without cleaning removed the symlink: %v", err)
+	}
+
+	if report, err = DaemonReload(true); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Removed dangling symlink " + link + " -> " + target; report != want {
+		t.Errorf("daemon-reload --clean = %q, want %q", report, want)
+	}
+	if _, err = os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("dangling symlink still exists after cleaning: %v", err)
+	}
+	if _, err = os.Lstat(filepath.Join(enablePath, "kept.service")); err != nil {
+		t.Errorf("cleaning removed a valid symlink: %v", err)
+	}
+
+	if report, _ = DaemonReload(false); report != "No dangling symlinks found." {
+		t.Errorf("daemon-reload after cleaning = %q", report)
+	}
+}
+
+// TestUnitPrecedence 检查同一单元同时存在于本地和软件包目录时，find、status和start都使用/etc中的文件。
+func TestUnitPrecedence(t *testing.T) {
+	dir := setupTestPaths(t)
+	out := filepath.Join(dir, "out")
+	for _, d := range []string{vendorUnitPath, localUnitPath} {
+		content := "[Service]\nType=oneshot\nRemainAfterExit=yes\nExecStart=/bin/sh -c \"echo " + d + " > " + out + "\"\n"
+		if err := os.WriteFile(filepath.Join(d, "both.service"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	local := filepath.Join(localUnitPath, "both.service")
+
+	if path := find("both"); path != local {
+		t.Errorf("find = %s, want %s", path, local)
+	}
+	status, err := Status("both")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(status, "Loaded: loaded ("+local+";") {
+		t.Errorf("status does not show the local unit file:\n%s", status)
+	}
+	if err = Start("both"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != localUnitPath+"\n" {
+		t.Errorf("start ran %q, want the command from %s", data, localUnitPath)
+	}
+
+	// 删除本地文件后回退到软件包目录
+	if err = os.Remove(local); err != nil {
+		t.Fatal(err)
+	}
+	if path, want := find("both"), filepath.Join(vendorUnitPath, "both.service"); path != want {
+		t.Errorf("find after removing the local unit = %s, want %s", path, want)
+	}
+}
+
+// TestStopNotRespondingEvent 检查忽略SIGTERM的服务在SIGKILL之前产生not-responding事件。
+func TestStopNotRespondingEvent(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "stubborn.service", "[Service]\nTimeoutStopSec=400ms\n"+
+		"ExecStart=/bin/sh -c \"trap '' TERM; while :; do sleep 0.05; done\"\n")
+	if err := Start("stubborn"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	events := subscribe("stubborn")
+	defer unsubscribe(events)
+
+	if err := Stop("stubborn"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for len(events) > 0 {
+		e := <-events
+		got = append(got, e.event+" "+e.detail)
+	}
+	want := []string{"not-responding SIGTERM after 200ms", "stopped "}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// maskPath 返回服务的屏蔽链接路径，与systemd一致位于localUnitPath下并指向/dev/null。
+func maskPath(service string) string {
+	return fmt.Sprintf("%s/%s.service", localUnitPath, service)
+}
+
+// isMasked 判断服务是否已被屏蔽。
+func isMasked(service string) bool {
+	target, err := os.Readlink(maskPath(service))
+	return err == nil && target == os.DevNull
+}
+
+// errMasked 返回启动或启用被屏蔽服务时的错误。
+func errMasked(service string) error {
+	return fmt.Errorf("unit %s.service is masked", service)
+}
+
+// Mask 将服务链接到/dev/null，使其无法被启动或启用。
+// 服务的单元文件本身位于localUnitPath时无法屏蔽。
+func Mask(service string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if isMasked(service) {
+		return nil
+	}
+	path := maskPath(service)
+	if _, err := os.Lstat(path); err == nil {
+		return fmt.Errorf("cannot mask %s.service: unit file %s exists", service, path)
+	}
+	if err := os.Symlink(os.DevNull, path); err != nil {
+		return err
+	}
+	log.Printf("Masked service %s\n", service)
+	return nil
+}
+
+// Unmask 移除服务的屏蔽链接。
+func Unmask(service string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !isMasked(service) {
+		return fmt.Errorf("unit %s.service is not masked", service)
+	}
+	if err := os.Remove(maskPath(service)); err != nil {
+		return err
+	}
+	log.Printf("Unmasked service %s\n", service)
+	return nil
+}
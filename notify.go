@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// notifySocket 是Type=notify服务的sd_notify通知套接字。
+// 每个服务实例使用独立的抽象Unix数据报套接字，通过NOTIFY_SOCKET传递给服务。
+type notifySocket struct {
+	// name 是套接字地址，以@开头表示抽象命名空间
+	name string
+	conn *net.UnixConn
+	// ready 在收到READY=1时关闭
+	ready chan struct{}
+	once  sync.Once
+	// mu 保护status
+	mu sync.Mutex
+	// status 是服务通过STATUS=上报的最新状态文本
+	status string
+	// pings 接收WATCHDOG=的值（1或trigger），由watchdog消费
+	pings chan string
+	// watchdogFired 表示服务因看门狗超时被终止
+	watchdogFired atomic.Bool
+}
+
+// notifyAccess 读取NotifyAccess=，未设置时为main。none忽略所有消息，main只接受主进程的消息，
+// all接受服务进程组中任意进程的消息。控制命令（ExecStartPre=等）不会得到NOTIFY_SOCKET，exec因此与main相同。
+func notifyAccess(opts []*unit.UnitOption) string {
+	val, _ := getOptions(opts, "Service", "NotifyAccess")
+	switch val {
+	case "none", "all":
+		return val
+	case "", "main", "exec":
+		return "main"
+	}
+	log.Printf("Invalid NotifyAccess: %s, using main\n", val)
+	return "main"
+}
+
+// newNotifySocket 为服务创建通知套接字。套接字开启SO_PASSCRED，内核为每条消息附上发送方的凭据。
+// 服务启动前收到的消息留在套接字中，由启动后调用的serve处理。
+func newNotifySocket(service string) (*notifySocket, error) {
+	name := fmt.Sprintf("@systemctl/notify/%s/%d", service, time.Now().UnixNano())
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create notify socket: %w", err)
+	}
+	raw, err := conn.SyscallConn()
+	if err == nil {
+		ctrlErr := raw.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+		})
+		if ctrlErr != nil {
+			err = ctrlErr
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to enable credentials on notify socket: %w", err)
+	}
+	return &notifySocket{name: name, conn: conn, ready: make(chan struct{}), pings: make(chan string, 1)}, nil
+}
+
+// serve 持续读取服务发送的通知消息，直到套接字被关闭。pid是服务的主进程，
+// 不符合access（NotifyAccess=）的发送方的消息被丢弃。
+func (n *notifySocket) serve(service string, pid int, access string) {
+	buf := make([]byte, 4096)
+	// 除凭据外为消息附带的文件描述符（如BARRIER=1的管道）留出空间
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofUcred)+syscall.CmsgSpace(16*4))
+	for {
+		size, oobn, _, _, err := n.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+		sender, fds := parseNotifyControl(oob[:oobn])
+		msg := string(buf[:size])
+		switch {
+		case strings.TrimSpace(msg) == "BARRIER=1":
+			// 屏障消息不改变服务状态，systemd-notify以自身的身份发送，关闭随附的管道即完成应答
+		case notifyAllowed(sender, pid, access):
+			n.handle(service, msg)
+		default:
+			log.Printf("Ignoring notification for %s from PID %d (NotifyAccess=%s)\n", service, sender, access)
+		}
+		// 不保存服务传来的文件描述符；关闭BARRIER=1的管道告知发送方之前的消息已处理完
+		for _, fd := range fds {
+			_ = syscall.Close(fd)
+		}
+	}
+}
+
+// handle 处理一条通知消息中的各行KEY=VALUE。
+func (n *notifySocket) handle(service, msg string) {
+	for _, line := range strings.Split(msg, "\n") {
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "READY":
+			if val == "1" {
+				log.Printf("Service %s signaled readiness\n", service)
+				n.once.Do(func() { close(n.ready) })
+			}
+		case "WATCHDOG":
+			select {
+			case n.pings <- val:
+			default:
+			}
+		case "STATUS":
+			n.mu.Lock()
+			n.status = val
+			n.mu.Unlock()
+		}
+	}
+}
+
+// parseNotifyControl 从消息的控制数据中取出发送方的PID（没有凭据时为0）和随消息传来的文件描述符。
+func parseNotifyControl(oob []byte) (sender int, fds []int) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, nil
+	}
+	for i := range msgs {
+		if cred, err := syscall.ParseUnixCredentials(&msgs[i]); err == nil {
+			sender = int(cred.Pid)
+		} else if rights, err := syscall.ParseUnixRights(&msgs[i]); err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	return sender, fds
+}
+
+// notifyAllowed 判断NotifyAccess=access时是否接受PID为sender的进程发给主进程为pid的服务的消息。
+// 服务的进程都在以主进程为首的进程组中，all据此判断发送方是否属于服务。
+func notifyAllowed(sender, pid int, access string) bool {
+	if sender <= 0 {
+		return false
+	}
+	switch access {
+	case "main":
+		return sender == pid
+	case "all":
+		if sender == pid {
+			return true
+		}
+		pgid, err := syscall.Getpgid(sender)
+		return err == nil && pgid == pid
+	}
+	return false
+}
+
+// statusText 返回服务最近上报的STATUS=文本。
+func (n *notifySocket) statusText() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.status
+}
+
+// close 关闭通知套接字。
+func (n *notifySocket) close() {
+	_ = n.conn.Close()
+}
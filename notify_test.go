@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestNotifyAccess 检查通知套接字按NotifyAccess=丢弃不属于服务的进程发送的READY=1。
+// systemd-notify以其父进程的身份发送消息，直接由主进程调用时消息来自主进程，
+// 经由中间的shell调用时消息来自服务进程组中的另一个进程。
+func TestNotifyAccess(t *testing.T) {
+	notify, err := exec.LookPath("systemd-notify")
+	if err != nil {
+		t.Skip("systemd-notify not available")
+	}
+	dir := setupTestPaths(t)
+	fromMain := filepath.Join(dir, "main.sh")
+	fromChild := filepath.Join(dir, "child.sh")
+	scripts := map[string]string{
+		fromMain:  "#!/bin/sh\n" + notify + " --ready\nexec sleep 60\n",
+		fromChild: "#!/bin/sh\n/bin/sh -c '" + notify + " --ready; true'\nexec sleep 60\n",
+	}
+	for path, content := range scripts {
+		if err = os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		access string
+		script string
+		ready  bool
+	}{
+		{"default-main", "", fromMain, true},
+		{"default-child", "", fromChild, false},
+		{"main-child", "NotifyAccess=main\n", fromChild, false},
+		{"exec-main", "NotifyAccess=exec\n", fromMain, true},
+		{"all-child", "NotifyAccess=all\n", fromChild, true},
+		{"none-main", "NotifyAccess=none\n", fromMain, false},
+	}
+	for _, tt := range tests {
+		writeUnit(t, tt.name+".service", "[Service]\nType=notify\nTimeoutStartSec=500ms\n"+tt.access+"ExecStart="+tt.script+"\n")
+		err := Start(tt.name)
+		if tt.ready && err != nil {
+			t.Errorf("%s: readiness notification was not accepted: %v", tt.name, err)
+		}
+		if !tt.ready && err == nil {
+			t.Errorf("%s: readiness notification from a process not allowed by NotifyAccess= was accepted", tt.name)
+		}
+	}
+}
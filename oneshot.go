@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// runOneshot 按顺序同步执行Type=oneshot服务的所有ExecStart命令，遇到第一个失败即返回，
+// 带"-"前缀的命令失败时继续执行。全部完成后执行ExecStartPost。
+// 调用方必须持有服务的锁和lock，等待命令退出期间释放lock，其他服务的操作和查询不必等待。
+func runOneshot(service string, opts []*unit.UnitOption, execCtx *execContext, execStarts []string) error {
+	// oneshot服务执行完毕即进入非运行状态，运行时目录和cgroup随之删除
+	defer removeRuntimeDirectories(service, opts)
+	defer removeCgroup(service)
+
+	settings, err := parseProcessSettings(opts)
+	if err != nil {
+		log.Printf("Failed to start service: %v\n", err)
+		return err
+	}
+	state := getState(service)
+	state.serviceType = "oneshot"
+	state.notify = nil
+
+	success := parseSuccessExitStatus(opts)
+	for _, val := range execStarts {
+		command, err := execCtx.command(val)
+		if err != nil {
+			log.Printf("Invalid ExecStart: %v\n", err)
+			return err
+		}
+		stdout, stderr, err := openOutput(service, opts)
+		if err != nil {
+			log.Printf("Failed to start service: %v\n", err)
+			return err
+		}
+		command.Stdout, command.Stderr = os.Stdout, os.Stderr
+		if stdout != nil {
+			command.Stdout = stdout
+		}
+		if stderr != nil {
+			command.Stderr = stderr
+		}
+
+		release := placeInCgroup(service, command, settings.cgroupLimits, settings.ipFilter)
+		log.Printf("Executing command: %s\n", command.String())
+		err = startWaited(command)
+		closeOutput(stdout, stderr)
+		release()
+		if err != nil {
+			log.Printf("Failed to start service: %v\n", err)
+			return err
+		}
+		settings.apply(service, command.Process.Pid)
+		unlocked(func() { _ = command.Wait() })
+		doneWaiting(command)
+
+		status, ok := waitStatus(command)
+		log.Printf("Service %s %s\n", service, describeExit(status, ok))
+		state.exitStatus, state.exitKnown = status, ok
+		if prefix, _ := splitExecPrefix(val); prefix.ignoreFailure && ok && status.Exited() {
+			continue
+		}
+		if (!ok || !status.Exited() || status.ExitStatus() != 0) && !success.matches(status, ok) {
+			state.inactiveEnter = time.Now()
+			state.result = exitResult(status, ok, success)
+			return fmt.Errorf("service %s %s", service, describeExit(status, ok))
+		}
+	}
+
+	log.Printf("Oneshot service %s finished successfully\n", service)
+	now := time.Now()
+	state.activeEnter, state.inactiveEnter = now, now
+
+	if err := execCtx.runControl(service, "ExecStartPost", getAllOptions(opts, "Service", "ExecStartPost")); err != nil {
+		log.Printf("Failed to run ExecStartPost: %v\n", err)
+	}
+	return nil
+}
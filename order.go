@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// unitNames 将After=、Before=等依赖指令的值拆分为服务名称列表，
+// 多个值以空白分隔，.service后缀会被去掉。
+func unitNames(values []string) []string {
+	var names []string
+	for _, val := range values {
+		for _, name := range strings.Fields(val) {
+			names = append(names, strings.TrimSuffix(name, ".service"))
+		}
+	}
+	return names
+}
+
+// orderServices 根据各服务单元中的After=和Before=对服务进行拓扑排序，返回启动顺序。
+// 只考虑services之间的顺序关系，无关的服务按名称排序以保证结果稳定。
+// 存在循环依赖时，cyclic返回无法排序的服务，它们按名称顺序追加在order末尾。
+func orderServices(services []string) (order, cyclic []string) {
+	set := map[string]bool{}
+	for _, service := range services {
+		set[service] = true
+	}
+	edges := orderingEdges(set)
+	inDegree := map[string]int{}
+	for _, afters := range edges {
+		for after := range afters {
+			inDegree[after]++
+		}
+	}
+
+	var queue []string
+	for service := range set {
+		if inDegree[service] == 0 {
+			queue = append(queue, service)
+		}
+	}
+	sort.Strings(queue)
+
+	for len(queue) > 0 {
+		service := queue[0]
+		queue = queue[1:]
+		order = append(order, service)
+		delete(set, service)
+
+		var next []string
+		for after := range edges[service] {
+			inDegree[after]--
+			if inDegree[after] == 0 {
+				next = append(next, after)
+			}
+		}
+		sort.Strings(next)
+		queue = append(queue, next...)
+	}
+
+	for service := range set {
+		cyclic = append(cyclic, service)
+	}
+	sort.Strings(cyclic)
+	return append(order, cyclic...), cyclic
+}
+
+// orderingEdges 根据After=和Before=返回set中服务之间的顺序关系，
+// edges[a]包含所有必须在a之后启动的服务。
+func orderingEdges(set map[string]bool) map[string]map[string]bool {
+	edges := map[string]map[string]bool{}
+	addEdge := func(before, after string) {
+		if !set[before] || !set[after] || before == after {
+			return
+		}
+		if edges[before] == nil {
+			edges[before] = map[string]bool{}
+		}
+		edges[before][after] = true
+	}
+	for service := range set {
+		opts, err := loadUnit(service)
+		if err != nil {
+			continue
+		}
+		for _, dep := range unitNames(getAllOptions(opts, "Unit", "After")) {
+			addEdge(dep, service)
+		}
+		for _, dep := range unitNames(getAllOptions(opts, "Unit", "Before")) {
+			addEdge(service, dep)
+		}
+	}
+	return edges
+}
+
+// maxDependencyDepth 限制Requires=/Wants=依赖链的最大深度，防止异常配置导致无限递归
+const maxDependencyDepth = 16
+
+// startDependencies 在启动服务之前拉起其Requires=和Wants=依赖，已在运行的依赖会被跳过。
+// Requires=依赖启动失败时返回错误，Wants=依赖的失败只记录日志。调用方必须持有服务的锁和lock。
+func startDependencies(service string, opts []*unit.UnitOption) error {
+	for _, dep := range unitNames(getAllOptions(opts, "Unit", "Requires")) {
+		if err := startDependency(service, dep); err != nil {
+			return fmt.Errorf("dependency %s.service of %s failed: %w", dep, service, err)
+		}
+	}
+	for _, dep := range unitNames(getAllOptions(opts, "Unit", "Wants")) {
+		if err := startDependency(service, dep); err != nil {
+			log.Printf("Wanted dependency %s.service of %s failed: %v\n", dep, service, err)
+		}
+	}
+	return nil
+}
+
+// startDependency 获取依赖服务的锁后按与start命令相同的流程启动它（包括条件检查、启动频率限制、
+// 它自己的依赖和冲突），依赖已在运行或条件不满足时不视为失败。调用方必须持有service的锁和lock。
+func startDependency(service, dep string) error {
+	unlock, err := lockDependency(service, dep)
+	if errors.Is(err, errLockHeld) {
+		log.Printf("Dependency cycle detected at %s.service, skipping\n", dep)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if isCommandRunning(dep, mapCommand[dep]) {
+		return nil
+	}
+	log.Printf("Starting dependency: %s\n", dep)
+	if err = start(dep); errors.Is(err, errAlreadyRunning) || errors.Is(err, errConditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// stopConflicts 停止服务Conflicts=中列出的正在运行的服务。
+// 冲突的服务同时出现在Requires=中时无法满足，直接返回错误。调用方必须持有service的锁和lock。
+func stopConflicts(service string, opts []*unit.UnitOption) error {
+	required := map[string]bool{}
+	for _, dep := range unitNames(getAllOptions(opts, "Unit", "Requires")) {
+		required[dep] = true
+	}
+	conflicts := unitNames(getAllOptions(opts, "Unit", "Conflicts"))
+	for _, conflict := range conflicts {
+		if required[conflict] {
+			return fmt.Errorf("%s.service both requires and conflicts with %s.service", service, conflict)
+		}
+	}
+	for _, conflict := range conflicts {
+		if err := stopConflict(service, conflict); err != nil {
+			return fmt.Errorf("failed to stop conflicting %s.service: %w", conflict, err)
+		}
+	}
+	return nil
+}
+
+// stopConflict 获取冲突服务的锁后停止它，未在运行时不做任何操作。调用方必须持有service的锁和lock。
+func stopConflict(service, conflict string) error {
+	if !isCommandRunning(conflict, mapCommand[conflict]) {
+		return nil
+	}
+	unlock, err := lockDependency(service, conflict)
+	if errors.Is(err, errLockHeld) {
+		return fmt.Errorf("%s.service is being started as a dependency of the same operation", conflict)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// 等待锁期间冲突的服务可能已被停止
+	if !isCommandRunning(conflict, mapCommand[conflict]) {
+		return nil
+	}
+	log.Printf("Stopping %s.service, which conflicts with %s.service\n", conflict, service)
+	return stop(conflict)
+}
+
+// dependencyKinds 是list-dependencies展示的依赖指令，只有Requires=和Wants=会被递归展开
+var dependencyKinds = []string{"Requires", "Wants", "After", "Before"}
+
+// ListDependencies 以树形结构返回服务的依赖关系。
+// Requires=和Wants=依赖会被递归展开，After=和Before=只列出不展开，循环依赖会被标记并停止展开。
+func ListDependencies(service string) (string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if find(service) == "" {
+		return "", errors.New("no service found")
+	}
+	var b strings.Builder
+	b.WriteString(service + ".service\n")
+	writeDependencies(&b, service, "", map[string]bool{service: true})
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// writeDependencies 将服务的依赖以prefix缩进写入b，path记录从根到当前服务的路径用于识别循环。
+func writeDependencies(b *strings.Builder, service, prefix string, path map[string]bool) {
+	opts, err := loadUnit(service)
+	if err != nil {
+		return
+	}
+	type edge struct{ name, kind string }
+	var edges []edge
+	for _, kind := range dependencyKinds {
+		for _, dep := range unitNames(getAllOptions(opts, "Unit", kind)) {
+			edges = append(edges, edge{dep, kind})
+		}
+	}
+
+	for i, e := range edges {
+		branch, indent := "├─", "│ "
+		if i == len(edges)-1 {
+			branch, indent = "└─", "  "
+		}
+		note := e.kind
+		expand := e.kind == "Requires" || e.kind == "Wants"
+		switch {
+		case expand && path[e.name]:
+			note += ", cycle"
+			expand = false
+		case expand && find(e.name) == "":
+			note += ", not found"
+			expand = false
+		case expand && len(path) > maxDependencyDepth:
+			note += ", too deep"
+			expand = false
+		}
+		_, _ = fmt.Fprintf(b, "%s%s%s.service (%s)\n", prefix, branch, e.name, note)
+		if expand {
+			path[e.name] = true
+			writeDependencies(b, e.name, prefix+indent, path)
+			delete(path, e.name)
+		}
+	}
+}
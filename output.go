@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// maxLogSize 是默认日志文件的大小上限，超过后在服务启动时轮转为<service>.log.1
+const maxLogSize = 10 << 20
+
+// defaultLogFile 返回服务默认的输出日志文件路径。
+func defaultLogFile(service string) string {
+	return filepath.Join(logPath, service+".log")
+}
+
+// outputTarget 解析StandardOutput=或StandardError=的值，返回输出文件路径和打开标志。
+// path为空表示继承守护进程的输出。未配置或journal、syslog等值写入默认日志文件。
+func outputTarget(service, val string) (path string, flag int) {
+	const base = os.O_WRONLY | os.O_CREATE
+	kind, arg, _ := strings.Cut(val, ":")
+	switch kind {
+	case "inherit", "tty":
+		return "", 0
+	case "null":
+		return os.DevNull, os.O_WRONLY
+	case "file":
+		return arg, base
+	case "append":
+		return arg, base | os.O_APPEND
+	case "truncate":
+		return arg, base | os.O_TRUNC
+	default:
+		return defaultLogFile(service), base | os.O_APPEND
+	}
+}
+
+// logFile 返回服务标准输出实际写入的文件，继承守护进程输出时返回空字符串。
+func logFile(service string, opts []*unit.UnitOption) string {
+	val, _ := getOptions(opts, "Service", "StandardOutput")
+	if isSyslog(val) {
+		return ""
+	}
+	path, _ := outputTarget(service, val)
+	return path
+}
+
+// openOutput 按StandardOutput=和StandardError=打开服务的输出文件。
+// StandardError=未配置时与标准输出相同，两者指向同一文件时共用一个文件描述符。
+// 值为syslog时转发到syslog。返回的文件在进程启动后应由调用方关闭，nil表示继承守护进程的输出。
+func openOutput(service string, opts []*unit.UnitOption) (stdout, stderr *os.File, err error) {
+	outVal, _ := getOptions(opts, "Service", "StandardOutput")
+	errVal, _ := getOptions(opts, "Service", "StandardError")
+	if errVal == "" || errVal == "inherit" {
+		errVal = outVal
+	}
+
+	if stdout, err = openStream(service, opts, outVal, syslog.LOG_INFO); err != nil {
+		return nil, nil, err
+	}
+	outPath, _ := outputTarget(service, outVal)
+	errPath, _ := outputTarget(service, errVal)
+	if errVal == outVal || (!isSyslog(outVal) && !isSyslog(errVal) && errPath == outPath) {
+		return stdout, stdout, nil
+	}
+	if stderr, err = openStream(service, opts, errVal, syslog.LOG_ERR); err != nil {
+		if stdout != nil {
+			_ = stdout.Close()
+		}
+		return nil, nil, err
+	}
+	return stdout, stderr, nil
+}
+
+// isSyslog 判断StandardOutput=或StandardError=的值是否要求转发到syslog。
+func isSyslog(val string) bool {
+	return val == "syslog"
+}
+
+// openStream 打开单个输出流。syslog输出通过管道逐行转发到syslog，
+// syslog不可用时回退到默认日志文件。
+func openStream(service string, opts []*unit.UnitOption, val string, priority syslog.Priority) (*os.File, error) {
+	if isSyslog(val) {
+		file, err := openSyslog(service, opts, priority)
+		if err == nil {
+			return file, nil
+		}
+		log.Printf("Syslog unavailable for %s, falling back to %s: %v\n", service, defaultLogFile(service), err)
+		val = ""
+	}
+	path, flag := outputTarget(service, val)
+	return openOutputFile(path, flag)
+}
+
+// openSyslog 创建一个管道，将写入的每一行以SyslogIdentifier=（默认为服务名）为标识转发到syslog。
+// 返回管道的写端，读端在所有写端关闭（即服务退出）后自动关闭。
+func openSyslog(service string, opts []*unit.UnitOption, priority syslog.Priority) (*os.File, error) {
+	tag, _ := getOptions(opts, "Service", "SyslogIdentifier")
+	if tag == "" {
+		tag = service
+	}
+	writer, err := syslog.New(priority|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		_ = writer.Close()
+		return nil, err
+	}
+	go func() {
+		defer func() { _ = r.Close() }()
+		defer func() { _ = writer.Close() }()
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if priority == syslog.LOG_ERR {
+				_ = writer.Err(scanner.Text())
+			} else {
+				_ = writer.Info(scanner.Text())
+			}
+		}
+	}()
+	return w, nil
+}
+
+// openOutputFile 打开一个输出文件，必要时创建父目录。path为空时返回nil。
+// 默认日志文件超过maxLogSize时先轮转，避免无限增长。
+func openOutputFile(path string, flag int) (*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if filepath.Dir(path) == logPath {
+		if info, err := os.Stat(path); err == nil && info.Size() > maxLogSize {
+			_ = os.Rename(path, path+".1")
+		}
+	}
+	file, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	return file, nil
+}
+
+// closeOutput 关闭openOutput返回的文件。
+func closeOutput(stdout, stderr *os.File) {
+	if stdout != nil {
+		_ = stdout.Close()
+	}
+	if stderr != nil && stderr != stdout {
+		_ = stderr.Close()
+	}
+}
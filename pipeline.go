@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Pipeline 从r逐行读取"operation service [args...]"格式的命令，在一个持久连接上依次发送给守护进程，
+// 并将每条命令的结果写入w。与batch不同，每条命令读取后立即执行，不需要等待输入结束。
+// 空行和以#开头的行会被忽略。任一命令失败时ok为false，但仍继续执行后续命令。
+func Pipeline(r io.Reader, w io.Writer) (ok bool, err error) {
+	conn, err := dial()
+	if err != nil {
+		return false, fmt.Errorf("Error connecting to daemon: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	token := clientToken()
+	ok = true
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return false, fmt.Errorf("invalid pipeline line: %q", line)
+		}
+		res, err2 := roundTrip(conn, token, strings.Join(fields, ":"))
+		if err2 != nil {
+			ok = false
+			_, _ = fmt.Fprintf(w, "%s %s: %v\n", fields[0], fields[1], err2)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s %s: %s\n", fields[0], fields[1], res)
+	}
+	return ok, scanner.Err()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Preflight 在不启动任何服务的情况下校验开机时默认目标拉起的所有单元能否启动，
+// 返回汇总报告以及是否全部通过。检查项包括：单元文件可以解析、环境变量文件可以读取、
+// ExecStart可执行文件在单元的PATH中存在且可执行、User=/Group=存在、KillSignal=是有效的信号、
+// Requires=依赖可以找到，以及After=/Before=之间没有循环。
+func Preflight() (string, bool) {
+	enabled := targetServices(bootTarget())
+	_, cyclic := orderServices(enabled)
+	inCycle := map[string]bool{}
+	for _, service := range cyclic {
+		inCycle[service] = true
+	}
+
+	var lines []string
+	failed := 0
+	for _, service := range enabled {
+		problems := checkUnit(service)
+		if inCycle[service] {
+			problems = append(problems, "ordering cycle in After=/Before=")
+		}
+		if len(problems) == 0 {
+			lines = append(lines, fmt.Sprintf("PASS %s.service", service))
+			continue
+		}
+		failed++
+		lines = append(lines, fmt.Sprintf("FAIL %s.service: %s", service, strings.Join(problems, "; ")))
+	}
+	lines = append(lines, fmt.Sprintf("\n%d units checked, %d failed.", len(enabled), failed))
+	return strings.Join(lines, "\n"), failed == 0
+}
+
+// checkUnit 对单个服务执行预检，返回发现的问题列表。
+func checkUnit(service string) []string {
+	opts, err := loadUnit(service)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	// 与启动时一样按单元的环境变量展开ExecStart，并在单元的PATH中查找可执行文件；
+	// 执行环境同时校验了EnvironmentFile=和User=/Group=
+	execCtx, err := newExecContext(opts)
+	if err != nil {
+		problems = append(problems, err.Error())
+		execCtx = &execContext{env: os.Environ()}
+	}
+	val, _ := getOptions(opts, "Service", "ExecStart")
+	if val == "" {
+		problems = append(problems, "ExecStart not found")
+	} else {
+		prefix, val := splitExecPrefix(val)
+		getenv := execCtx.getenv
+		if prefix.noExpand {
+			getenv = nil
+		}
+		name, _, err := parseCommand(val, getenv)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid ExecStart: %v", err))
+		} else if _, err = lookExecutable(name, execCtx.getenv("PATH")); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if val, _ = getOptions(opts, "Service", "KillSignal"); val != "" {
+		if _, err = parseSignal(val); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid KillSignal: %v", err))
+		}
+	}
+
+	for _, dep := range unitNames(getAllOptions(opts, "Unit", "Requires")) {
+		if find(dep) == "" {
+			problems = append(problems, fmt.Sprintf("required unit %s.service not found", dep))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	dir := setupTestPaths(t)
+	bin := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "mytool"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	units := map[string]string{
+		"good":        "[Service]\nExecStart=/bin/true\n",
+		"unitpath":    "[Service]\nEnvironment=PATH=" + bin + "\nExecStart=mytool --flag\n",
+		"expanded":    "[Service]\nEnvironment=TOOL=" + filepath.Join(bin, "mytool") + "\nExecStart=${TOOL}\n",
+		"noexec":      "[Service]\nExecStart=/nonexistent/daemon\n",
+		"nopath":      "[Service]\nExecStart=mytool\n",
+		"baduser":     "[Service]\nUser=no-such-user-preflight\nExecStart=/bin/true\n",
+		"badsignal":   "[Service]\nKillSignal=SIGBOGUS\nExecStart=/bin/true\n",
+		"missing":     "[Unit]\nRequires=nowhere.service\n[Service]\nExecStart=/bin/true\n",
+		"noexecstart": "[Service]\nType=simple\n",
+	}
+	for name, content := range units {
+		writeUnit(t, name+".service", content)
+		enableForTest(t, name)
+	}
+	// 没有被默认目标拉起的单元不参与检查
+	writeUnit(t, "disabled.service", "[Service]\nExecStart=/nonexistent/daemon\n")
+
+	report, ok := Preflight()
+	if ok {
+		t.Fatalf("Preflight passed with invalid units:\n%s", report)
+	}
+	want := map[string]string{
+		"good":        "PASS good.service",
+		"unitpath":    "PASS unitpath.service",
+		"expanded":    "PASS expanded.service",
+		"noexec":      "FAIL noexec.service: executable /nonexistent/daemon not found",
+		"nopath":      "FAIL nopath.service:",
+		"baduser":     "FAIL baduser.service: invalid User no-such-user-preflight",
+		"badsignal":   "FAIL badsignal.service: invalid KillSignal",
+		"missing":     "FAIL missing.service: required unit nowhere.service not found",
+		"noexecstart": "FAIL noexecstart.service: ExecStart not found",
+	}
+	for name, prefix := range want {
+		found := false
+		for _, line := range strings.Split(report, "\n") {
+			if strings.HasPrefix(line, prefix) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("report has no line for %s starting with %q:\n%s", name, prefix, report)
+		}
+	}
+	if strings.Contains(report, "disabled.service") {
+		t.Errorf("report includes a unit that is not pulled in by the boot target:\n%s", report)
+	}
+	if !strings.Contains(report, "9 units checked, 6 failed.") {
+		t.Errorf("unexpected summary:\n%s", report)
+	}
+}
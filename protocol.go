@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxFrameSize 限制单帧的最大长度，防止异常请求耗尽内存
+const maxFrameSize = 16 << 20
+
+// writeFrame 以"4字节大端长度+内容"的格式写入一帧数据。
+func writeFrame(w io.Writer, data []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame 读取一帧由writeFrame写入的数据。
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 响应帧的第一个字节表示请求是否成功，其后是可读的响应文本或错误信息
+const (
+	responseOK    byte = 0
+	responseError byte = 1
+)
+
+// encodeResponse 将处理结果编码为响应帧的内容。
+func encodeResponse(text string, err error) []byte {
+	if err != nil {
+		return append([]byte{responseError}, err.Error()...)
+	}
+	return append([]byte{responseOK}, text...)
+}
+
+// decodeResponse 解析encodeResponse编码的响应，失败的响应以error返回。
+func decodeResponse(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "", errors.New("empty response")
+	}
+	switch data[0] {
+	case responseOK:
+		return string(data[1:]), nil
+	case responseError:
+		return "", errors.New(string(data[1:]))
+	default:
+		return "", fmt.Errorf("invalid response status %d", data[0])
+	}
+}
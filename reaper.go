@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var (
+	// waitedPIDs 记录有专门goroutine调用Wait的子进程，全局回收器不会回收它们
+	waitedPIDs = map[int]bool{}
+	// waitLock 保护waitedPIDs，并保证进程启动与登记之间不会被回收器打断
+	waitLock sync.Mutex
+)
+
+// startWaited 启动命令并将其登记为由调用方自行Wait的进程。
+// 调用方在Wait返回后必须调用doneWaiting。
+func startWaited(command *exec.Cmd) error {
+	waitLock.Lock()
+	defer waitLock.Unlock()
+	if err := command.Start(); err != nil {
+		return err
+	}
+	waitedPIDs[command.Process.Pid] = true
+	return nil
+}
+
+// doneWaiting 在命令的Wait返回后取消登记。
+func doneWaiting(command *exec.Cmd) {
+	waitLock.Lock()
+	defer waitLock.Unlock()
+	delete(waitedPIDs, command.Process.Pid)
+}
+
+// reapZombies 持续回收僵尸进程以防止资源泄露。
+// 此函数在goroutine中运行，每次收到SIGCHLD时回收所有没有专门等待者的子进程
+// （如作为PID 1时被收养的孤儿进程），由startWaited启动的进程留给各自的Wait获取退出状态。
+func reapZombies() {
+	// 信号会合并，因此每次收到SIGCHLD都回收所有可回收的僵尸进程
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGCHLD)
+	reapOrphans()
+	for range sigCh {
+		reapOrphans()
+	}
+}
+
+// reapOrphans 扫描/proc，回收所有未登记的僵尸子进程。
+func reapOrphans() {
+	waitLock.Lock()
+	defer waitLock.Unlock()
+	for _, pid := range zombieChildren() {
+		if waitedPIDs[pid] {
+			continue
+		}
+		var status syscall.WaitStatus
+		// WNOHANG: 非阻塞模式，只回收指定的子进程
+		if reaped, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err == nil && reaped > 0 {
+			log.Printf("Reaped zombie process PID: %d\n", pid)
+		}
+	}
+}
+
+// zombieChildren 返回当前进程所有处于僵尸状态的子进程PID。
+func zombieChildren() []int {
+	self := os.Getpid()
+	paths, _ := filepath.Glob("/proc/[0-9]*/stat")
+	var pids []int
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// 格式为"pid (comm) state ppid ..."，comm可能包含空格和括号，从最后一个")"之后解析
+		i := bytes.LastIndexByte(data, ')')
+		if i < 0 {
+			continue
+		}
+		fields := bytes.Fields(data[i+1:])
+		if len(fields) < 2 || string(fields[0]) != "Z" {
+			continue
+		}
+		if ppid, _ := strconv.Atoi(string(fields[1])); ppid != self {
+			continue
+		}
+		if pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path))); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// processSettings 是应用到服务主进程的调度和资源设置。
+// Go的SysProcAttr不支持在fork后设置Nice=等属性，因此它们在进程启动后立即通过PID应用，
+// 进程在此之前派生的子进程不受影响；cgroup限制和IP地址过滤则在启动之前通过placeInCgroup安排。
+type processSettings struct {
+	// nice 是Nice=配置的优先级，为nil时不修改
+	nice *int
+	// oomScoreAdjust 是OOMScoreAdjust=配置的值，为nil时不修改
+	oomScoreAdjust *int
+	// rlimits 是Limit*=配置的资源限制，键为资源编号
+	rlimits map[int]syscall.Rlimit
+	// cgroupLimits 是MemoryMax=等需要通过服务独立的cgroup实施的限制
+	cgroupLimits []cgroupLimit
+	// ipFilter 是IPAddressAllow=和IPAddressDeny=，同样通过服务独立的cgroup实施，未配置时为nil
+	ipFilter *ipFilter
+}
+
+// rlimitNPROC 是RLIMIT_NPROC的资源编号，syscall包中没有定义
+const rlimitNPROC = 6
+
+// rlimitInfinity 表示不限制
+const rlimitInfinity = ^uint64(0)
+
+// rlimitOptions 将支持的Limit*=指令映射到资源编号
+var rlimitOptions = map[string]int{
+	"LimitNOFILE": syscall.RLIMIT_NOFILE,
+	"LimitNPROC":  rlimitNPROC,
+	"LimitCORE":   syscall.RLIMIT_CORE,
+	"LimitFSIZE":  syscall.RLIMIT_FSIZE,
+	"LimitSTACK":  syscall.RLIMIT_STACK,
+	"LimitAS":     syscall.RLIMIT_AS,
+}
+
+// parseProcessSettings 解析并校验服务的Nice=、OOMScoreAdjust=、Limit*=、cgroup资源限制和IP地址过滤。
+func parseProcessSettings(opts []*unit.UnitOption) (*processSettings, error) {
+	settings := &processSettings{}
+	var err error
+	if settings.nice, err = intOption(opts, "Nice", -20, 19); err != nil {
+		return nil, err
+	}
+	if settings.oomScoreAdjust, err = intOption(opts, "OOMScoreAdjust", -1000, 1000); err != nil {
+		return nil, err
+	}
+	for name, resource := range rlimitOptions {
+		val, _ := getOptions(opts, "Service", name)
+		if val == "" {
+			continue
+		}
+		limit, err := parseRlimit(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if settings.rlimits == nil {
+			settings.rlimits = map[int]syscall.Rlimit{}
+		}
+		settings.rlimits[resource] = limit
+	}
+	if settings.cgroupLimits, err = parseCgroupLimits(opts); err != nil {
+		return nil, err
+	}
+	if settings.ipFilter, err = parseIPFilter(opts); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// parseRlimit 解析Limit*=的值，支持"软限制:硬限制"形式，只给出一个值时软硬限制相同。
+// 每个值可以是infinity或带K、M、G、T（1024进制）后缀的数字。
+func parseRlimit(val string) (syscall.Rlimit, error) {
+	softVal, hardVal, ok := strings.Cut(val, ":")
+	if !ok {
+		hardVal = softVal
+	}
+	soft, err := parseRlimitValue(softVal)
+	if err != nil {
+		return syscall.Rlimit{}, err
+	}
+	hard, err := parseRlimitValue(hardVal)
+	if err != nil {
+		return syscall.Rlimit{}, err
+	}
+	if soft > hard {
+		return syscall.Rlimit{}, fmt.Errorf("soft limit %s exceeds hard limit %s", softVal, hardVal)
+	}
+	return syscall.Rlimit{Cur: soft, Max: hard}, nil
+}
+
+// parseRlimitValue 解析单个资源限制值。
+func parseRlimitValue(val string) (uint64, error) {
+	val = strings.TrimSpace(val)
+	if val == "infinity" {
+		return rlimitInfinity, nil
+	}
+	multiplier := uint64(1)
+	if n := len(val); n > 0 {
+		if i := strings.IndexByte("KMGT", val[n-1]); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			val = val[:n-1]
+		}
+	}
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid limit: %s", val)
+	}
+	return n * multiplier, nil
+}
+
+// prlimit 设置指定进程的资源限制。
+func prlimit(pid, resource int, limit *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// intOption 读取[Service]中取值范围为[min, max]的整数选项，未配置时返回nil。
+func intOption(opts []*unit.UnitOption, name string, min, max int) (*int, error) {
+	val, _ := getOptions(opts, "Service", name)
+	if val == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", name, val)
+	}
+	if n < min || n > max {
+		return nil, fmt.Errorf("%s=%d out of range [%d, %d]", name, n, min, max)
+	}
+	return &n, nil
+}
+
+// apply 将设置应用到已启动的进程，失败时只记录日志。
+func (s *processSettings) apply(service string, pid int) {
+	if s.nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, *s.nice); err != nil {
+			log.Printf("Failed to set Nice for %s: %v\n", service, err)
+		}
+	}
+	for resource, limit := range s.rlimits {
+		if err := prlimit(pid, resource, &limit); err != nil {
+			log.Printf("Failed to set resource limit %d for %s: %v\n", resource, service, err)
+		}
+	}
+	if s.oomScoreAdjust != nil {
+		path := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+		if err := os.WriteFile(path, []byte(strconv.Itoa(*s.oomScoreAdjust)), 0644); err != nil {
+			log.Printf("Failed to set OOMScoreAdjust for %s: %v\n", service, err)
+		}
+	}
+}
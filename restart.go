@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// cleanSignals 是按systemd约定视为正常终止的信号
+var cleanSignals = map[syscall.Signal]bool{
+	syscall.SIGHUP:  true,
+	syscall.SIGINT:  true,
+	syscall.SIGTERM: true,
+	syscall.SIGPIPE: true,
+}
+
+// waitStatus 返回已退出命令的等待状态。进程状态不可用时（如已被其他等待者回收）ok为false。
+func waitStatus(command *exec.Cmd) (status syscall.WaitStatus, ok bool) {
+	if command.ProcessState == nil {
+		return 0, false
+	}
+	status, ok = command.ProcessState.Sys().(syscall.WaitStatus)
+	return status, ok
+}
+
+// successStatus 是SuccessExitStatus=中额外视为正常结束的退出码和信号。
+type successStatus struct {
+	codes   map[int]bool
+	signals map[syscall.Signal]bool
+}
+
+// parseSuccessExitStatus 解析[Service]中的SuccessExitStatus=，每个值可包含多个以空格分隔的退出码（0-255）
+// 或信号名称（如143 SIGKILL），空值清除之前的设置。无法识别的项记录日志后忽略。
+func parseSuccessExitStatus(opts []*unit.UnitOption) successStatus {
+	success := successStatus{codes: map[int]bool{}, signals: map[syscall.Signal]bool{}}
+	for _, val := range getAllOptions(opts, "Service", "SuccessExitStatus") {
+		for _, field := range strings.Fields(val) {
+			if code, err := strconv.Atoi(field); err == nil {
+				if code < 0 || code > 255 {
+					log.Printf("Invalid exit status in SuccessExitStatus=: %s\n", field)
+					continue
+				}
+				success.codes[code] = true
+				continue
+			}
+			sig, err := parseSignal(field)
+			if err != nil {
+				log.Printf("Invalid SuccessExitStatus=: %v\n", err)
+				continue
+			}
+			success.signals[sig] = true
+		}
+	}
+	return success
+}
+
+// matches 判断等待状态是否匹配SuccessExitStatus=中的退出码或信号。
+func (s successStatus) matches(status syscall.WaitStatus, ok bool) bool {
+	if !ok {
+		return false
+	}
+	return (status.Exited() && s.codes[status.ExitStatus()]) || (status.Signaled() && s.signals[status.Signal()])
+}
+
+// cleanExit 判断进程是否按systemd约定正常结束：以0退出、被正常终止信号杀死，
+// 或退出码、信号在SuccessExitStatus=中。
+func cleanExit(status syscall.WaitStatus, ok bool, success successStatus) bool {
+	if !ok {
+		return false
+	}
+	return (status.Exited() && status.ExitStatus() == 0) || (status.Signaled() && cleanSignals[status.Signal()]) ||
+		success.matches(status, ok)
+}
+
+// exitResult 按systemd的Result=取值描述进程的退出方式。
+func exitResult(status syscall.WaitStatus, ok bool, success successStatus) string {
+	switch {
+	case cleanExit(status, ok, success):
+		return "success"
+	case ok && status.Signaled() && status.CoreDump():
+		return "core-dump"
+	case ok && status.Signaled():
+		return "signal"
+	default:
+		return "exit-code"
+	}
+}
+
+// shouldRestart 根据Restart=策略和进程的等待状态判断是否需要自动重启服务，
+// SuccessExitStatus=中的退出码和信号视为正常结束，watchdog表示进程是被看门狗超时终止的。
+// 与systemd一致，on-abnormal在未捕获的信号和看门狗超时时重启，on-abort只在未捕获的信号时重启。
+// 未配置或无法识别的策略按"no"处理；unless-stopped等同于always，显式停止的服务本来就不会被重启。
+func shouldRestart(policy string, status syscall.WaitStatus, ok bool, success successStatus, watchdog bool) bool {
+	// 无法获取退出状态时按非正常退出码处理
+	clean := cleanExit(status, ok, success)
+	signaled := ok && status.Signaled()
+
+	switch policy {
+	case "always", "unless-stopped":
+		return true
+	case "on-success":
+		return clean
+	case "on-failure":
+		return !clean || watchdog
+	case "on-abnormal":
+		return watchdog || (signaled && !clean)
+	case "on-abort":
+		return !watchdog && signaled && !clean
+	default:
+		return false
+	}
+}
+
+// execMainStatus 以systemd的格式返回退出方式（exited、killed、dumped）和对应的退出码或信号名称。
+func execMainStatus(status syscall.WaitStatus) (code, detail string) {
+	switch {
+	case status.Signaled() && status.CoreDump():
+		return "dumped", signalName(status.Signal())
+	case status.Signaled():
+		return "killed", signalName(status.Signal())
+	default:
+		return "exited", strconv.Itoa(status.ExitStatus())
+	}
+}
+
+// describeExit 描述进程的退出方式，用于日志记录。
+func describeExit(status syscall.WaitStatus, ok bool) string {
+	if !ok {
+		return "exited with unknown status"
+	}
+	code, detail := execMainStatus(status)
+	switch code {
+	case "dumped":
+		return fmt.Sprintf("was killed by signal %s (core dumped)", detail)
+	case "killed":
+		return fmt.Sprintf("was killed by signal %s", detail)
+	default:
+		return fmt.Sprintf("exited with code %s", detail)
+	}
+}
+
+// defaultRestartSec 是未配置RestartSec=时自动重启前的等待时间
+const defaultRestartSec = 5 * time.Second
+
+// restartBackoff 读取RestartSec=、RestartMaxDelaySec=和RestartSteps=。
+// maxDelay或steps为0表示不使用指数退避。
+func restartBackoff(opts []*unit.UnitOption) (base, maxDelay time.Duration, steps int) {
+	base = defaultRestartSec
+	if val, _ := getOptions(opts, "Service", "RestartSec"); val != "" {
+		if d, err := parseTimeSpan(val); err != nil {
+			log.Printf("Invalid RestartSec: %v\n", err)
+		} else {
+			base = d
+		}
+	}
+	if val, _ := getOptions(opts, "Service", "RestartMaxDelaySec"); val != "" {
+		if d, err := parseTimeSpan(val); err != nil {
+			log.Printf("Invalid RestartMaxDelaySec: %v\n", err)
+		} else {
+			maxDelay = d
+		}
+	}
+	if val, _ := getOptions(opts, "Service", "RestartSteps"); val != "" {
+		if n, err := strconv.Atoi(val); err != nil || n < 0 {
+			log.Printf("Invalid RestartSteps: %s\n", val)
+		} else {
+			steps = n
+		}
+	}
+	return base, maxDelay, steps
+}
+
+// restartDelay 返回第n次（从0开始）连续自动重启前的等待时间。与systemd一致，设置了RestartSteps=和
+// RestartMaxDelaySec=时，等待时间在RestartSteps次重启内从RestartSec按指数增长到RestartMaxDelaySec，
+// 之后保持不变；否则固定为RestartSec。
+func restartDelay(opts []*unit.UnitOption, n int) time.Duration {
+	base, maxDelay, steps := restartBackoff(opts)
+	if maxDelay <= base || steps == 0 || maxDelay == timeSpanInfinity {
+		return base
+	}
+	if n >= steps {
+		return maxDelay
+	}
+	// 起点至少为100ms，避免RestartSec=0时无法按比例增长
+	start := max(base, 100*time.Millisecond)
+	ratio := float64(maxDelay) / float64(start)
+	return time.Duration(float64(start) * math.Pow(ratio, float64(n)/float64(steps)))
+}
+
+// stopAfterExit 在退出的服务不再重启时清理其RuntimeDirectory和cgroup，单元文件已被删除时按CollectMode=回收它。
+// 调用方不能持有lock。
+func stopAfterExit(service string, opts []*unit.UnitOption) {
+	removeRuntimeDirectories(service, opts)
+	removeCgroup(service)
+	lock.Lock()
+	collectUnit(service)
+	lock.Unlock()
+}
+
+// restartAfterExit 在服务实例command退出后按Restart=策略决定是否自动重启，不重启时清理RuntimeDirectory。
+// 等待RestartSec期间服务被停止或被新实例替换时不再重启。
+// watchdog表示实例是被看门狗超时终止的。调用方不能持有lock。
+func restartAfterExit(service string, command *exec.Cmd, opts []*unit.UnitOption, status syscall.WaitStatus, ok, watchdog bool) {
+	policy, _ := getOptions(opts, "Service", "Restart")
+	if !shouldRestart(policy, status, ok, parseSuccessExitStatus(opts), watchdog) {
+		log.Printf("Service %s not restarted (Restart=%s)\n", service, policy)
+		stopAfterExit(service, opts)
+		return
+	}
+	if detectCrashLoop(service, opts) {
+		stopAfterExit(service, opts)
+		return
+	}
+
+	lock.Lock()
+	state := getState(service)
+	// 实例稳定运行超过最大退避时间后，重新从RestartSec开始计算
+	if _, maxDelay, _ := restartBackoff(opts); maxDelay > 0 && state.inactiveEnter.Sub(state.activeEnter) >= maxDelay {
+		state.autoRestarts = 0
+	}
+	delay := restartDelay(opts, state.autoRestarts)
+	state.autoRestarts++
+	lock.Unlock()
+
+	log.Printf("Restarting service %s in %v\n", service, delay)
+	emit(service, "restarting", "in "+delay.String())
+	time.Sleep(delay)
+
+	defer lockService(service)()
+	// stop会从mapCommand中移除退出的实例，start和restart会替换它
+	if mapCommand[service] != command {
+		log.Printf("Service %s was stopped or replaced during RestartSec, not restarting\n", service)
+		return
+	}
+	log.Printf("Attempting to restart service: %s\n", service)
+	getState(service).restarts++
+	if err := start(service); err != nil && !errors.Is(err, errAlreadyRunning) {
+		log.Printf("Failed to restart service: %v\n", err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// exitStatus 和 signalStatus 构造Linux上对应的等待状态
+func exitStatus(code int) syscall.WaitStatus { return syscall.WaitStatus(code << 8) }
+
+func signalStatus(sig syscall.Signal) syscall.WaitStatus { return syscall.WaitStatus(sig) }
+
+func TestShouldRestart(t *testing.T) {
+	success := parseSuccessExitStatus([]*unit.UnitOption{
+		{Section: "Service", Name: "SuccessExitStatus", Value: "75 SIGUSR1"},
+	})
+	tests := []struct {
+		policy   string
+		status   syscall.WaitStatus
+		ok       bool
+		watchdog bool
+		want     bool
+	}{
+		{"", exitStatus(1), true, false, false},
+		{"no", signalStatus(syscall.SIGKILL), true, false, false},
+		{"bogus", exitStatus(1), true, false, false},
+		{"always", exitStatus(0), true, false, true},
+		{"unless-stopped", exitStatus(0), true, false, true},
+		{"on-success", exitStatus(0), true, false, true},
+		{"on-success", signalStatus(syscall.SIGTERM), true, false, true},
+		{"on-success", exitStatus(75), true, false, true},
+		{"on-success", exitStatus(1), true, false, false},
+		{"on-failure", exitStatus(1), true, false, true},
+		{"on-failure", exitStatus(0), true, false, false},
+		{"on-failure", exitStatus(75), true, false, false},
+		{"on-failure", signalStatus(syscall.SIGUSR1), true, false, false},
+		{"on-failure", 0, false, false, true},
+		{"on-failure", signalStatus(syscall.SIGABRT), true, true, true},
+		{"on-abnormal", exitStatus(1), true, false, false},
+		{"on-abnormal", signalStatus(syscall.SIGSEGV), true, false, true},
+		{"on-abnormal", signalStatus(syscall.SIGTERM), true, false, false},
+		{"on-abnormal", signalStatus(syscall.SIGABRT), true, true, true},
+		{"on-abort", exitStatus(1), true, false, false},
+		{"on-abort", signalStatus(syscall.SIGSEGV), true, false, true},
+		{"on-abort", signalStatus(syscall.SIGTERM), true, false, false},
+		{"on-abort", signalStatus(syscall.SIGABRT), true, true, false},
+		{"on-abort", 0, false, false, false},
+	}
+	for _, tt := range tests {
+		got := shouldRestart(tt.policy, tt.status, tt.ok, success, tt.watchdog)
+		if got != tt.want {
+			t.Errorf("shouldRestart(%q, %#x, %v, watchdog=%v) = %v, want %v", tt.policy, int(tt.status), tt.ok, tt.watchdog, got, tt.want)
+		}
+	}
+}
+
+func TestRestartCounter(t *testing.T) {
+	dir := setupTestPaths(t)
+	// 第一次运行时崩溃，自动重启后保持运行
+	marker := filepath.Join(dir, "crashed")
+	writeUnit(t, "flaky.service", "[Service]\nRestart=on-failure\nRestartSec=10ms\n"+
+		"ExecStart=/bin/sh -c \"test -e "+marker+" && exec sleep 60; touch "+marker+"; sleep 0.1; exit 3\"\n")
+	restarts := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return mapState["flaky"].restarts
+	}
+
+	if err := Start("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if n := restarts(); n != 0 {
+		t.Fatalf("NRestarts = %d before any crash", n)
+	}
+	waitFor(t, 5*time.Second, "automatic restart after a crash", func() bool { return restarts() == 1 && isRunning("flaky") })
+
+	if err := Restart("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	properties := serviceProperties("flaky", nil, resourceUsage{})
+	lock.Unlock()
+	if !slices.Contains(properties, "NRestarts=2") {
+		t.Errorf("properties %q do not contain NRestarts=2 after a crash and a restart command", properties)
+	}
+
+	if err := Stop("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetFailed("flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if n := restarts(); n != 0 {
+		t.Errorf("NRestarts = %d after reset-failed, want 0", n)
+	}
+}
+
+// TestRestartCounterFailedRestart 检查失败的restart命令不计入NRestarts。
+func TestRestartCounterFailedRestart(t *testing.T) {
+	setupTestPaths(t)
+	writeUnit(t, "broken.service", "[Unit]\nAssertPathExists=/nonexistent\n[Service]\nExecStart=/bin/sleep 60\n")
+	if err := Restart("broken"); err == nil {
+		t.Fatal("restart of a unit with a failing assertion succeeded")
+	}
+	lock.Lock()
+	n := getState("broken").restarts
+	lock.Unlock()
+	if n != 0 {
+		t.Errorf("NRestarts = %d after a failed restart, want 0", n)
+	}
+}
+
+// TestStopDuringRestartSec 检查在等待RestartSec期间停止的Restart=always服务不会被重新启动。
+func TestStopDuringRestartSec(t *testing.T) {
+	dir := setupTestPaths(t)
+	started := filepath.Join(dir, "started")
+	writeUnit(t, "crasher.service", "[Service]\nRestart=always\nRestartSec=300ms\n"+
+		"ExecStart=/bin/sh -c \"echo >> "+started+"; sleep 0.1; exit 1\"\n")
+	events := subscribe("crasher")
+	defer unsubscribe(events)
+	if err := Start("crasher"); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for e := (unitEvent{}); e.event != "restarting"; {
+		select {
+		case e = <-events:
+		case <-timeout:
+			t.Fatal("timed out waiting for the restarting event")
+		}
+	}
+	if err := Stop("crasher"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	if isRunning("crasher") {
+		t.Error("service was restarted after being stopped during RestartSec")
+	}
+	if data, _ := os.ReadFile(started); strings.Count(string(data), "\n") != 1 {
+		t.Errorf("service started %d times, want 1", strings.Count(string(data), "\n"))
+	}
+}
+
+// TestOverlapRestart 检查X-RestartMode=overlap时新实例启动后的宽限期内旧实例一直在运行，
+// 宽限期结束后旧实例才被停止。
+func TestOverlapRestart(t *testing.T) {
+	dir := setupTestPaths(t)
+	started := filepath.Join(dir, "started")
+	script := filepath.Join(dir, "overlap.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho $$ >> "+started+"\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "overlap.service", "[Service]\nRestart=always\nX-RestartMode=overlap\nX-RestartOverlapSec=300ms\nExecStart="+script+"\n")
+	if err := Start("overlap"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	old := mapCommand["overlap"].Process.Pid
+	lock.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- Restart("overlap") }()
+	waitFor(t, 5*time.Second, "new instance to start", func() bool {
+		data, _ := os.ReadFile(started)
+		return strings.Count(string(data), "\n") == 2
+	})
+	if !processAlive(old) {
+		t.Error("old instance was stopped before the new instance became ready")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	current := mapCommand["overlap"].Process.Pid
+	lock.Unlock()
+	if current == old {
+		t.Fatal("restart kept the old instance")
+	}
+	waitFor(t, time.Second, "old instance to exit", func() bool { return !processAlive(old) })
+	if !isRunning("overlap") {
+		t.Error("new instance is not running after the restart")
+	}
+}
+
+// TestOverlapRestartNotify 检查Type=notify服务的overlap重启在新实例发送READY=1之前保留旧实例。
+func TestOverlapRestartNotify(t *testing.T) {
+	notify, err := exec.LookPath("systemd-notify")
+	if err != nil {
+		t.Skip("systemd-notify not available")
+	}
+	dir := setupTestPaths(t)
+	started := filepath.Join(dir, "started")
+	script := filepath.Join(dir, "overlap.sh")
+	content := "#!/bin/sh\necho $$ >> " + started + "\nsleep 0.3\n" + notify + " --ready\nexec sleep 60\n"
+	if err = os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "overlap.service", "[Service]\nType=notify\nNotifyAccess=all\nRestart=always\nX-RestartMode=overlap\nExecStart="+script+"\n")
+	if err = Start("overlap"); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	old := mapCommand["overlap"].Process.Pid
+	lock.Unlock()
+
+	done := make(chan error, 1)
+	go func() { done <- Restart("overlap") }()
+	waitFor(t, 5*time.Second, "new instance to start", func() bool {
+		data, _ := os.ReadFile(started)
+		return strings.Count(string(data), "\n") == 2
+	})
+	if !processAlive(old) {
+		t.Error("old instance was stopped before the new instance signaled readiness")
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "old instance to exit", func() bool { return !processAlive(old) })
+	if !isRunning("overlap") {
+		t.Error("new instance is not running after the restart")
+	}
+}
+
+// TestWatchdogAbortTimeout 检查看门狗超时后忽略SIGABRT的服务在TimeoutAbortSec之后连同其进程组被SIGKILL终止。
+func TestWatchdogAbortTimeout(t *testing.T) {
+	notify, err := exec.LookPath("systemd-notify")
+	if err != nil {
+		t.Skip("systemd-notify not available")
+	}
+	dir := setupTestPaths(t)
+	childPid := filepath.Join(dir, "child")
+	script := filepath.Join(dir, "hang.sh")
+	content := "#!/bin/sh\ntrap '' ABRT\nsleep 60 &\necho $! > " + childPid + "\n" + notify + " --ready\nexec sleep 60\n"
+	if err = os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeUnit(t, "hang.service", "[Service]\nType=notify\nNotifyAccess=all\nWatchdogSec=200ms\nTimeoutAbortSec=300ms\nExecStart="+script+"\n")
+	if err = Start("hang"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(childPid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+
+	waitFor(t, 5*time.Second, "service to be killed", func() bool { return !isRunning("hang") })
+	lock.Lock()
+	state := getState("hang")
+	result, status := state.result, state.exitStatus
+	lock.Unlock()
+	if result != "watchdog" {
+		t.Errorf("result = %q, want watchdog", result)
+	}
+	if !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		t.Errorf("service exited with %v, want SIGKILL", status)
+	}
+	waitFor(t, time.Second, "child to be killed", func() bool { return !processAlive(child) })
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// unitLoadState 返回服务的LoadState：loaded、masked或not-found。
+func unitLoadState(service string) string {
+	switch {
+	case isMasked(service):
+		return "masked"
+	case find(service) == "":
+		return "not-found"
+	default:
+		return "loaded"
+	}
+}
+
+// serviceProperties 以"键=值"的形式返回服务的运行时属性，usage是运行中服务的资源用量。
+// 调用方必须持有lock。
+func serviceProperties(service string, opts []*unit.UnitOption, usage resourceUsage) []string {
+	state := mapState[service]
+	command := mapCommand[service]
+	running := isCommandRunning(service, command)
+	activeState, subState := unitActiveState(service, opts)
+
+	properties := []string{
+		"LoadState=" + unitLoadState(service),
+		"ActiveState=" + activeState,
+		"SubState=" + subState,
+	}
+	if running {
+		properties = append(properties, fmt.Sprintf("MainPID=%d", mainPID(service, command)))
+		if usage.tasks > 0 {
+			properties = append(properties,
+				fmt.Sprintf("TasksCurrent=%d", usage.tasks),
+				fmt.Sprintf("MemoryCurrent=%d", usage.rss),
+				fmt.Sprintf("CPUUsageNSec=%d", usage.cpu.Nanoseconds()))
+		}
+	}
+	if state == nil {
+		return properties
+	}
+	if state.result != "" {
+		properties = append(properties, "Result="+state.result)
+	}
+	properties = append(properties, fmt.Sprintf("NRestarts=%d", state.restarts))
+	if state.crashLoop != "" {
+		crashLoop := "failed"
+		if state.crashLoopDisabled {
+			crashLoop = "disabled"
+		}
+		properties = append(properties, "X-CrashLoop="+crashLoop)
+	}
+	if !state.activeEnter.IsZero() {
+		properties = append(properties, "ActiveEnterTimestamp="+formatTimestamp(state.activeEnter))
+	}
+	if !state.inactiveEnter.IsZero() {
+		properties = append(properties, "InactiveEnterTimestamp="+formatTimestamp(state.inactiveEnter))
+	}
+	if state.exitKnown {
+		code, status := execMainStatus(state.exitStatus)
+		properties = append(properties, "ExecMainCode="+code, "ExecMainStatus="+status)
+	}
+	if state.collectMode != "" {
+		properties = append(properties, "CollectMode="+state.collectMode)
+	}
+	if state.notify != nil && state.notify.statusText() != "" {
+		properties = append(properties, "StatusText="+state.notify.statusText())
+	}
+	if running {
+		freezerState := "running"
+		if state.frozen {
+			freezerState = "frozen"
+		}
+		properties = append(properties, "FreezerState="+freezerState)
+	}
+	return properties
+}
+
+// unitSections 是show查找单元文件指令时依次搜索的节
+var unitSections = []string{"Unit", "Service", "Install"}
+
+// Show 以每行一个"键=值"的形式返回服务的属性。names为空时返回所有运行时属性和单元文件中的全部指令；
+// 否则按顺序只返回指定的属性，先查运行时属性（如MainPID），再按Unit、Service、Install的顺序查找单元文件中的指令。
+// 可以出现多次的指令（如ExecStartPre）每个值一行，未设置的属性返回空值。
+func Show(service string, names []string) (string, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	opts, err := loadUnit(service)
+	if err != nil && mapState[service] == nil {
+		return "", errors.New("no service found")
+	}
+	var usage resourceUsage
+	if command := mapCommand[service]; isCommandRunning(service, command) {
+		usage = serviceUsage(mainPID(service, command))
+	}
+	properties := serviceProperties(service, opts, usage)
+	if len(names) == 0 {
+		for _, option := range opts {
+			properties = append(properties, option.Name+"="+option.Value)
+		}
+		return strings.Join(properties, "\n"), nil
+	}
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, showProperty(name, properties, opts)...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// showProperty 返回单个属性的"键=值"行。
+func showProperty(name string, properties []string, opts []*unit.UnitOption) []string {
+	for _, property := range properties {
+		if strings.HasPrefix(property, name+"=") {
+			return []string{property}
+		}
+	}
+	for _, section := range unitSections {
+		values := getAllOptions(opts, section, name)
+		if len(values) == 0 {
+			continue
+		}
+		lines := make([]string, len(values))
+		for i, val := range values {
+			lines[i] = name + "=" + val
+		}
+		return lines
+	}
+	return []string{name + "="}
+}
+
+// parseShowArgs 解析show的参数：-p/--property可以出现多次，每个值也可以是以","分隔的多个属性名。
+func parseShowArgs(args []string) (service string, names []string, err error) {
+	for i := 0; i < len(args); i++ {
+		var val string
+		switch {
+		case args[i] == "-p" || args[i] == "--property":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("option %s requires a value", args[i])
+			}
+			val = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--property="):
+			val = strings.TrimPrefix(args[i], "--property=")
+		default:
+			service = args[i]
+			continue
+		}
+		for _, name := range strings.Split(val, ",") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	if service == "" {
+		return "", nil, errors.New("service name required")
+	}
+	return service, names, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// defaultSocketMode 是控制套接字的默认权限，只有root和属组成员可以发送命令
+const defaultSocketMode = 0660
+
+// socketMode 返回控制套接字的权限。可通过SYSTEMCTL_SOCKET_MODE环境变量以八进制指定，
+// 例如设为0777以兼容旧版本允许任意用户访问的行为。
+func socketMode() (os.FileMode, error) {
+	val := os.Getenv("SYSTEMCTL_SOCKET_MODE")
+	if val == "" {
+		return defaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(val, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid SYSTEMCTL_SOCKET_MODE: %s", val)
+	}
+	return os.FileMode(mode), nil
+}
+
+// socketGroup 返回SYSTEMCTL_SOCKET_GROUP环境变量指定的控制套接字属组，可以是组名或GID。
+// 未设置时返回-1，表示不修改属组。
+func socketGroup() (int, error) {
+	val := os.Getenv("SYSTEMCTL_SOCKET_GROUP")
+	if val == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(val); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SYSTEMCTL_SOCKET_GROUP %s: %w", val, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// secureSocket 按配置设置控制套接字的属组和权限。
+func secureSocket(path string) error {
+	mode, err := socketMode()
+	if err != nil {
+		return err
+	}
+	gid, err := socketGroup()
+	if err != nil {
+		return err
+	}
+	if gid >= 0 {
+		if err = os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, mode)
+}
+
+// listenTCP 在address上打开TCP监听器，使用与Unix套接字相同的协议，供远程管理使用。
+// 令牌只用于认证，连接本身不加密，因此默认关闭，只应绑定到回环地址或受防火墙保护的内部网络。
+// 没有配置令牌时，能连接到该地址的任何人都能以root身份启停服务，此时记录警告。
+// 调用方必须先加载authToken。
+func listenTCP(address string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if authToken == "" {
+		log.Printf("WARNING: no token configured in %s, listening for unauthenticated commands on TCP %s\n", tokenPath, listener.Addr())
+	} else {
+		log.Printf("Listening for authenticated commands on TCP %s\n", listener.Addr())
+	}
+	return listener, nil
+}
+
+// dial 连接守护进程：设置了remoteAddress时通过TCP连接，否则连接本机的Unix套接字。
+func dial() (net.Conn, error) {
+	if remoteAddress != "" {
+		return net.Dial("tcp", remoteAddress)
+	}
+	return net.Dial("unix", socketPath)
+}
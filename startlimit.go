@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// defaultStartLimitInterval 和 defaultStartLimitBurst 是未配置时的启动频率限制，与systemd一致
+const (
+	defaultStartLimitInterval = 10 * time.Second
+	defaultStartLimitBurst    = 5
+)
+
+// startLimit 读取[Unit]中的StartLimitIntervalSec和StartLimitBurst。
+// interval为0表示不限制启动频率。
+func startLimit(opts []*unit.UnitOption) (time.Duration, int) {
+	interval := defaultStartLimitInterval
+	if val, _ := getOptions(opts, "Unit", "StartLimitIntervalSec"); val != "" {
+		d, err := parseTimeSpan(val)
+		if err != nil {
+			log.Printf("Invalid StartLimitIntervalSec: %v\n", err)
+		} else {
+			interval = d
+		}
+	}
+	burst := defaultStartLimitBurst
+	if val, _ := getOptions(opts, "Unit", "StartLimitBurst"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			log.Printf("Invalid StartLimitBurst: %s\n", val)
+		} else {
+			burst = n
+		}
+	}
+	return interval, burst
+}
+
+// checkStartLimit 记录一次启动并检查服务是否超过启动频率限制。
+// 在StartLimitIntervalSec内启动超过StartLimitBurst次时将服务标记为失败，
+// 之后的启动（包括自动重启）都会被拒绝，直到执行reset-failed。调用方必须持有lock。
+func checkStartLimit(service string, opts []*unit.UnitOption) error {
+	state := getState(service)
+	if state.startLimitHit {
+		return fmt.Errorf("start request repeated too quickly for %s.service, use reset-failed to allow starting it again", service)
+	}
+	if state.crashLoop != "" {
+		return fmt.Errorf("%s.service is crash looping (%s), use reset-failed to allow starting it again", service, state.crashLoop)
+	}
+	interval, burst := startLimit(opts)
+	if interval == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	starts := state.starts[:0]
+	for _, t := range state.starts {
+		if now.Sub(t) < interval {
+			starts = append(starts, t)
+		}
+	}
+	state.starts = append(starts, now)
+	if len(state.starts) > burst {
+		state.startLimitHit = true
+		state.result = "start-limit-hit"
+		log.Printf("Service %s started more than %d times within %v, marking it failed\n", service, burst, interval)
+		return fmt.Errorf("start request repeated too quickly for %s.service", service)
+	}
+	return nil
+}
+
+// ResetFailed 清除服务的失败状态、启动历史、重启计数和崩溃循环状态，使其可以再次启动。
+// 单元文件已被删除的服务随后被回收。
+// 因崩溃循环被禁用的服务不会被重新启用。
+// 服务不处于失败状态且没有重启过时返回错误。
+func ResetFailed(service string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	state := mapState[service]
+	if state == nil || (!state.startLimitHit && !state.failed && state.restarts == 0 && state.crashLoop == "") {
+		return fmt.Errorf("unit %s.service is not in a failed state", service)
+	}
+	state.startLimitHit = false
+	state.failed = false
+	state.result = "success"
+	state.starts = nil
+	state.restarts = 0
+	state.crashes = nil
+	state.crashLoop = ""
+	state.crashLoopDisabled = false
+	log.Printf("Reset failed state of service %s\n", service)
+	collectUnit(service)
+	return nil
+}
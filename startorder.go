@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StartOrder 计算守护进程启动时default.target拉起的服务的启动计划，但不启动任何服务。
+// 每个服务标出它所在的步骤：同一步骤的服务互不依赖，可以并行启动（受--boot-concurrency限制），
+// 并列出它需要等待的After=/Before=前驱，以及启动它之前由Requires=/Wants=拉起的其他服务。
+// 存在循环依赖时列出每个循环，以及因排在循环之后而按名称顺序启动的服务。
+func StartOrder() string {
+	target := bootTarget()
+	order, cyclic := orderServices(targetServices(target))
+	if len(order) == 0 {
+		return fmt.Sprintf("No units are started by %s.", target)
+	}
+	before := bootPredecessors(order, cyclic)
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "Start order for %s (at most %d units in parallel):\n", target, bootConcurrency())
+	_, _ = fmt.Fprintf(&b, "%-5s %-30s %s\n", "STEP", "UNIT", "AFTER")
+	step := map[string]int{}
+	planned := map[string]bool{}
+	for _, service := range order {
+		planned[service] = true
+	}
+	for _, service := range order {
+		step[service] = 1
+		var afters []string
+		for _, dep := range before[service] {
+			step[service] = max(step[service], step[dep]+1)
+			afters = append(afters, dep+".service")
+		}
+		after := "-"
+		if len(afters) > 0 {
+			after = strings.Join(afters, " ")
+		}
+		_, _ = fmt.Fprintf(&b, "%-5d %-30s %s\n", step[service], service+".service", after)
+		writePulledIn(&b, service, planned, 0)
+	}
+
+	_, _ = fmt.Fprintf(&b, "\n%d units planned.", len(order))
+	if len(cyclic) > 0 {
+		b.WriteString("\n")
+		cycles, blocked := orderingCycles(cyclic)
+		for _, cycle := range cycles {
+			_, _ = fmt.Fprintf(&b, "\nOrdering cycle among %s.service", strings.Join(cycle, ".service, "))
+		}
+		if len(blocked) > 0 {
+			_, _ = fmt.Fprintf(&b, "\nOrdered after a cycle: %s.service", strings.Join(blocked, ".service, "))
+		}
+		b.WriteString("\nAfter=/Before= among these units is ignored at boot.")
+	}
+	return b.String()
+}
+
+// writePulledIn 按启动的先后写出服务启动前由Requires=和Wants=拉起、且不在启动计划中的服务，
+// 依赖的依赖排在前面，与startDependencies的顺序一致。
+// planned记录已经会被启动的服务，避免重复列出和循环依赖导致无限递归。
+func writePulledIn(b *strings.Builder, service string, planned map[string]bool, depth int) {
+	if depth > maxDependencyDepth {
+		return
+	}
+	opts, err := loadUnit(service)
+	if err != nil {
+		return
+	}
+	for _, kind := range []string{"Requires", "Wants"} {
+		for _, dep := range unitNames(getAllOptions(opts, "Unit", kind)) {
+			if planned[dep] {
+				continue
+			}
+			planned[dep] = true
+			writePulledIn(b, dep, planned, depth+1)
+			note := ""
+			if find(dep) == "" {
+				note = ", not found"
+			}
+			_, _ = fmt.Fprintf(b, "%-5s └─ %s.service (%s= of %s.service%s)\n", "", dep, kind, service, note)
+		}
+	}
+}
+
+// orderingCycles 将orderServices无法排序的服务分为真正的循环（按After=/Before=相互可达的服务组，
+// 每组按名称排序并从名称最小的服务开始）和只是排在某个循环之后的服务。
+func orderingCycles(cyclic []string) (cycles [][]string, blocked []string) {
+	set := map[string]bool{}
+	for _, service := range cyclic {
+		set[service] = true
+	}
+	edges := orderingEdges(set)
+	reaches := func(from, to string) bool {
+		seen := map[string]bool{from: true}
+		queue := []string{from}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			for next := range edges[current] {
+				if next == to {
+					return true
+				}
+				if !seen[next] {
+					seen[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		return false
+	}
+
+	grouped := map[string]bool{}
+	for _, service := range cyclic {
+		if grouped[service] {
+			continue
+		}
+		var cycle []string
+		for _, other := range cyclic {
+			if other == service || (reaches(service, other) && reaches(other, service)) {
+				cycle = append(cycle, other)
+			}
+		}
+		if len(cycle) < 2 {
+			blocked = append(blocked, service)
+			continue
+		}
+		sort.Strings(cycle)
+		for _, member := range cycle {
+			grouped[member] = true
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, blocked
+}
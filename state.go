@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// savedService 是状态文件中记录的一个运行中服务。
+type savedService struct {
+	PID int `json:"pid"`
+	// StartTime 是进程的启动时间（/proc/<pid>/stat第22个字段），用于识别PID是否已被复用
+	StartTime   uint64    `json:"start_time,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	ActiveEnter time.Time `json:"active_enter"`
+}
+
+// saveState 将所有运行中服务的主进程PID写入statePath。调用方必须持有lock。
+func saveState() error {
+	services := map[string]savedService{}
+	for service, command := range mapCommand {
+		if !isCommandRunning(service, command) {
+			continue
+		}
+		pid := mainPID(service, command)
+		saved := savedService{PID: pid, StartTime: processStartTime(pid)}
+		if state := mapState[service]; state != nil {
+			saved.Type = state.serviceType
+			saved.ActiveEnter = state.activeEnter
+		}
+		services[service] = saved
+	}
+	data, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+	tmp := statePath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath)
+}
+
+// restoreState 读取statePath并接管其中仍然存活的服务进程，返回已接管的服务。
+// 状态文件由daemon-reexec写入，或由persistState定期写入以便守护进程崩溃后恢复。
+// 状态文件读取后即删除，避免之后的启动误接管已被复用的PID。
+func restoreState() map[string]bool {
+	adopted := map[string]bool{}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return adopted
+	}
+	_ = os.Remove(statePath)
+	services := map[string]savedService{}
+	if err = json.Unmarshal(data, &services); err != nil {
+		log.Printf("Invalid state file %s: %v\n", statePath, err)
+		return adopted
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	for service, saved := range services {
+		if !isProcessRunning(saved.PID) || (saved.StartTime != 0 && processStartTime(saved.PID) != saved.StartTime) {
+			log.Printf("Service %s (PID: %d) exited while the daemon was not running\n", service, saved.PID)
+			continue
+		}
+		adopt(service, saved)
+		adopted[service] = true
+	}
+	return adopted
+}
+
+// stateInterval 是定期保存服务状态的间隔
+const stateInterval = 5 * time.Second
+
+// persistState 定期将运行中服务的状态写入statePath，使守护进程崩溃重启后能重新接管这些服务。
+func persistState() {
+	for {
+		time.Sleep(stateInterval)
+		lock.Lock()
+		if !shuttingDown {
+			if err := saveState(); err != nil {
+				log.Printf("Failed to save state: %v\n", err)
+			}
+		}
+		lock.Unlock()
+	}
+}
+
+// processStartTime 返回进程的启动时间（自系统启动以来的时钟节拍数），无法读取时返回0。
+func processStartTime(pid int) uint64 {
+	// comm之后第20个字段为starttime
+	fields := procStat(pid)
+	if len(fields) < 20 {
+		return 0
+	}
+	start, _ := strconv.ParseUint(fields[19], 10, 64)
+	return start
+}
+
+// readPIDFile 读取PIDFile=指向的文件中的PID。
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID in %s", path)
+	}
+	return pid, nil
+}
+
+// pidFilePID 返回服务PIDFile=中记录的仍然存活的PID。未设置PIDFile=、文件不可读或
+// 其中的进程已经退出（过期的PID文件）时返回0。调用方必须持有lock。
+func pidFilePID(service string) int {
+	state := mapState[service]
+	if state == nil || state.pidFile == "" {
+		return 0
+	}
+	pid, err := readPIDFile(state.pidFile)
+	if err != nil || !isProcessRunning(pid) {
+		return 0
+	}
+	return pid
+}
+
+// mainPID 返回服务的主进程PID：优先使用PIDFile=中存活的PID，否则为启动的进程。调用方必须持有lock。
+func mainPID(service string, command *exec.Cmd) int {
+	if pid := pidFilePID(service); pid > 0 {
+		return pid
+	}
+	return command.Process.Pid
+}
+
+// adoptRunning 检查服务的PIDFile=，若其中记录的进程仍然存活则接管它并返回true。
+// 用于守护进程启动时，服务已由镜像入口脚本等其他方式启动的情况。调用方必须持有lock。
+func adoptRunning(service string) bool {
+	opts, err := loadUnit(service)
+	if err != nil {
+		return false
+	}
+	path, _ := getOptions(opts, "Service", "PIDFile")
+	if path == "" {
+		return false
+	}
+	pid, err := readPIDFile(path)
+	if err != nil || !isProcessRunning(pid) {
+		return false
+	}
+	serviceType, _ := getOptions(opts, "Service", "Type")
+	adopt(service, savedService{PID: pid, Type: serviceType, ActiveEnter: time.Now()})
+	getState(service).pidFile = path
+	return true
+}
+
+// adopt 接管一个不是由当前进程启动的服务进程。调用方必须持有lock。
+// 由于没有对应的exec.Cmd可以Wait，通过轮询/proc判断进程是否退出，退出状态视为未知。
+func adopt(service string, saved savedService) {
+	process, err := os.FindProcess(saved.PID)
+	if err != nil {
+		return
+	}
+	command := &exec.Cmd{Process: process}
+	command.Path, _ = os.Readlink("/proc/" + strconv.Itoa(saved.PID) + "/exe")
+	if cmdline, err2 := os.ReadFile("/proc/" + strconv.Itoa(saved.PID) + "/cmdline"); err2 == nil {
+		command.Args = strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	}
+	mapCommand[service] = command
+	state := getState(service)
+	state.serviceType = saved.Type
+	state.activeEnter = saved.ActiveEnter
+	log.Printf("Adopted running service %s (PID: %d)\n", service, saved.PID)
+
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			lock.Lock()
+			if mapCommand[service] != command {
+				lock.Unlock()
+				return
+			}
+			if !isCommandRunning(service, command) {
+				break
+			}
+			lock.Unlock()
+		}
+		log.Printf("Service %s %s\n", service, describeExit(0, false))
+		state := getState(service)
+		state.inactiveEnter = time.Now()
+		state.exitStatus, state.exitKnown = 0, false
+		emit(service, "exited", describeExit(0, false))
+		lock.Unlock()
+
+		opts, err2 := loadUnit(service)
+		if err2 != nil {
+			log.Printf("Failed to load service file: %v\n", err2)
+			return
+		}
+		restartAfterExit(service, command, opts, 0, false, false)
+	}()
+}
+
+// reexec 保存运行中服务的状态后重新执行守护进程自身，用于升级systemctl而不中断服务。
+// 服务进程在exec后仍是守护进程的子进程，新进程启动时从状态文件接管它们。
+func reexec() {
+	lock.Lock()
+	defer lock.Unlock()
+	reexecRequested.Store(false)
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Printf("Failed to re-execute daemon: %v\n", err)
+		return
+	}
+	if err = saveState(); err != nil {
+		log.Printf("Failed to save state: %v\n", err)
+		return
+	}
+	log.Printf("Re-executing daemon: %s\n", self)
+	args := append(append([]string{os.Args[0]}, globalFlagArgs()...), "domain")
+	err = syscall.Exec(self, args, os.Environ())
+	log.Printf("Failed to re-execute daemon: %v\n", err)
+	_ = os.Remove(statePath)
+}
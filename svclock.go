@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// lockChain 是一次操作（如一次start及其拉起的依赖）持有的服务锁链，用于在获取依赖或冲突服务的锁时识别循环和死锁。
+// 字段受serviceLocksLock保护。
+type lockChain struct {
+	// waiting 是当前正在等待的服务锁
+	waiting *serviceLock
+	// depth 是通过lockDependency额外持有的服务锁数量
+	depth int
+}
+
+// serviceLock 是单个服务的锁。
+type serviceLock struct {
+	sync.Mutex
+	// owner 是持有该锁的锁链，受serviceLocksLock保护
+	owner *lockChain
+}
+
+var (
+	// serviceLocks 为每个服务串行化启动、停止等会等待进程的操作
+	serviceLocks = map[string]*serviceLock{}
+	// serviceLocksLock 保护serviceLocks以及各serviceLock和lockChain的字段
+	serviceLocksLock sync.Mutex
+)
+
+// errLockHeld 表示依赖的服务锁已被当前锁链持有，即依赖关系中存在循环
+var errLockHeld = errors.New("service lock is already held by this operation")
+
+// getServiceLock 返回服务的锁，不存在时创建。调用方必须持有serviceLocksLock。
+func getServiceLock(service string) *serviceLock {
+	l := serviceLocks[service]
+	if l == nil {
+		l = &serviceLock{}
+		serviceLocks[service] = l
+	}
+	return l
+}
+
+// lockService 依次获取服务的锁和全局lock，返回按相反顺序释放它们的函数。
+// 持有服务锁的操作可以在等待进程就绪或退出期间通过unlocked暂时释放lock，
+// 使其他服务的操作和status等查询不必等待，而同一服务上的其他操作仍被串行化。
+// 服务锁必须在lock之前获取，持有lock时不能再获取服务锁；需要同时操作其他服务时使用lockDependency。
+func lockService(service string) (unlock func()) {
+	serviceLocksLock.Lock()
+	l := getServiceLock(service)
+	serviceLocksLock.Unlock()
+
+	l.Lock()
+	serviceLocksLock.Lock()
+	l.owner = &lockChain{}
+	serviceLocksLock.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		serviceLocksLock.Lock()
+		l.owner = nil
+		serviceLocksLock.Unlock()
+		l.Unlock()
+	}
+}
+
+// lockDependency 在持有service的服务锁和lock时获取另一个服务other的锁，用于启动Requires=/Wants=依赖
+// 或停止Conflicts=中的服务，返回释放other的锁的函数。等待期间释放lock，返回时重新持有lock。
+// other的锁已被同一锁链持有（循环依赖）时返回errLockHeld；other的持有者直接或间接地在等待
+// 本锁链持有的锁时，等待会造成死锁，此时不等待而返回错误。
+func lockDependency(service, other string) (unlock func(), err error) {
+	serviceLocksLock.Lock()
+	chain := getServiceLock(service).owner
+	l := getServiceLock(other)
+	if chain == nil {
+		serviceLocksLock.Unlock()
+		return nil, fmt.Errorf("lock of %s.service is not held", service)
+	}
+	if l.owner == chain {
+		serviceLocksLock.Unlock()
+		return nil, errLockHeld
+	}
+	if chain.depth >= maxDependencyDepth {
+		serviceLocksLock.Unlock()
+		return nil, fmt.Errorf("dependency chain of %s exceeds maximum depth %d", service, maxDependencyDepth)
+	}
+	// 沿"持有者正在等待的锁的持有者"追溯，回到本锁链说明等待会形成环
+	for o, n := l.owner, 0; o != nil && o.waiting != nil && n <= len(serviceLocks); o, n = o.waiting.owner, n+1 {
+		if o.waiting.owner == chain {
+			serviceLocksLock.Unlock()
+			return nil, fmt.Errorf("%s.service is being changed by another operation that waits for %s.service", other, service)
+		}
+	}
+	chain.waiting = l
+	serviceLocksLock.Unlock()
+
+	unlocked(l.Lock)
+	serviceLocksLock.Lock()
+	chain.waiting = nil
+	chain.depth++
+	l.owner = chain
+	serviceLocksLock.Unlock()
+	return func() {
+		serviceLocksLock.Lock()
+		chain.depth--
+		l.owner = nil
+		serviceLocksLock.Unlock()
+		l.Unlock()
+	}, nil
+}
+
+// unlocked 暂时释放lock执行f，返回前重新获取lock。调用方必须持有lock，
+// 且在f返回后重新检查可能已被其他goroutine修改的状态。
+func unlocked(f func()) {
+	lock.Unlock()
+	defer lock.Lock()
+	f()
+}
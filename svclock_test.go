@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentOperations 并发地对几个服务反复执行start、stop、restart、status和show，
+// 其中一个服务在释放lock等待ExecStartPre和ExecStop，另一个会不断崩溃并被自动重启。需要用go test -race运行才能发现对共享状态的无锁访问。
+func TestConcurrentOperations(t *testing.T) {
+	dir := setupTestPaths(t)
+	stop := filepath.Join(dir, "stop.sh")
+	if err := os.WriteFile(stop, []byte("#!/bin/sh\nkill \"$MAINPID\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	services := []string{"steady", "controlled", "crashing"}
+	writeUnit(t, "steady.service", "[Service]\nExecStart=/bin/sleep 60\n")
+	writeUnit(t, "controlled.service", "[Service]\nExecStartPre=/bin/sleep 0.01\nExecStart=/bin/sleep 60\nExecStop="+stop+"\n")
+	writeUnit(t, "crashing.service", "[Unit]\nStartLimitBurst=1000\n[Service]\nRestart=always\nRestartSec=10ms\n"+
+		"ExecStart=/bin/sh -c \"sleep 0.05; exit 1\"\n")
+	ops := []string{"start", "stop", "restart", "status", "show"}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 20; i++ {
+				service := services[r.Intn(len(services))]
+				// 结果取决于并发的时序，这里只检查不会死锁、崩溃或出现数据竞争
+				_, _ = dispatch(ops[r.Intn(len(ops))], service, nil)
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	for _, service := range services {
+		_ = Stop(service)
+		if isRunning(service) {
+			t.Errorf("%s is still running after stop", service)
+		}
+	}
+}
+
+// BenchmarkParallelStart 同时启动多个互不相关的服务，每个服务的ExecStartPre耗时50ms。
+// 等待控制命令时持有全局锁的话每次迭代约为服务数×50ms，按服务加锁后接近50ms。
+func BenchmarkParallelStart(b *testing.B) {
+	setupTestPaths(b)
+	const services = 8
+	for i := 0; i < services; i++ {
+		writeUnit(b, fmt.Sprintf("bench%d.service", i),
+			"[Service]\nType=oneshot\nExecStartPre=/bin/sleep 0.05\nExecStart=/bin/true\n")
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var wg sync.WaitGroup
+		for i := 0; i < services; i++ {
+			wg.Add(1)
+			go func(service string) {
+				defer wg.Done()
+				if err := Start(service); err != nil {
+					b.Error(err)
+				}
+			}(fmt.Sprintf("bench%d", i))
+		}
+		wg.Wait()
+	}
+}
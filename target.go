@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTargetLink 返回default.target符号链接的路径，它指向守护进程启动时进入的目标。
+func defaultTargetLink() string {
+	return filepath.Join(localUnitPath, "default.target")
+}
+
+// bootTarget 返回default.target指向的目标，未设置时为multi-user.target。
+func bootTarget() string {
+	if dest, err := os.Readlink(defaultTargetLink()); err == nil {
+		return filepath.Base(dest)
+	}
+	return defaultTarget
+}
+
+// isTarget 判断目标是否存在：有单元文件、有.wants目录，或是默认目标。
+func isTarget(target string) bool {
+	if target == defaultTarget || findUnitFile(target) != "" {
+		return true
+	}
+	_, err := os.Stat(wantsDir(target))
+	return err == nil
+}
+
+// targetServices 返回目标拉起的所有服务：目标.wants目录中的服务，以及目标单元文件的
+// Requires=/Wants=和.wants目录中引用的其他目标递归拉起的服务。
+func targetServices(target string) []string {
+	var services []string
+	seen := map[string]bool{}
+	var visit func(target string)
+	visit = func(target string) {
+		if seen[target] {
+			return
+		}
+		seen[target] = true
+
+		if path := findUnitFile(target); path != "" {
+			if content, err := os.ReadFile(path); err == nil {
+				if opts, err2 := parseSystemdService(string(content)); err2 == nil {
+					deps := append(getAllOptions(opts, "Unit", "Requires"), getAllOptions(opts, "Unit", "Wants")...)
+					for _, dep := range unitNames(deps) {
+						if strings.HasSuffix(dep, ".target") {
+							visit(dep)
+						}
+					}
+				}
+			}
+		}
+
+		entries, _ := os.ReadDir(wantsDir(target))
+		for _, entry := range entries {
+			name := entry.Name()
+			switch {
+			case strings.HasSuffix(name, ".target"):
+				visit(name)
+			case name == "e2scrub_reap.service":
+			case strings.HasSuffix(name, ".service") && !seen[name]:
+				seen[name] = true
+				services = append(services, strings.TrimSuffix(name, ".service"))
+			}
+		}
+	}
+	visit(target)
+	return services
+}
+
+// SetDefault 将default.target指向target，守护进程下次启动时进入该目标。
+func SetDefault(target string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !isTarget(target) {
+		return fmt.Errorf("no target found: %s", target)
+	}
+	dest := findUnitFile(target)
+	if dest == "" {
+		dest = target
+	}
+	link := defaultTargetLink()
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(dest, link)
+}
+
+// Isolate 切换到target：停止所有不被target拉起的运行中服务，再按依赖顺序启动target拉起的服务。
+func Isolate(target string) error {
+	lock.Lock()
+	if !isTarget(target) {
+		lock.Unlock()
+		return fmt.Errorf("no target found: %s", target)
+	}
+	wanted := map[string]bool{}
+	services := targetServices(target)
+	for _, service := range services {
+		wanted[service] = true
+	}
+	var unwanted []string
+	for service, command := range mapCommand {
+		if !wanted[service] && isCommandRunning(service, command) {
+			unwanted = append(unwanted, service)
+		}
+	}
+	lock.Unlock()
+
+	log.Printf("Isolating %s\n", target)
+	var failed []string
+	order, _ := orderServices(unwanted)
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := Stop(order[i]); err != nil {
+			log.Printf("Failed to stop service %s: %v\n", order[i], err)
+			failed = append(failed, order[i])
+		}
+	}
+	order, _ = orderServices(services)
+	for _, service := range order {
+		if err := Start(service); err != nil && !errors.Is(err, errAlreadyRunning) && !errors.Is(err, errConditionFailed) {
+			log.Printf("Failed to start service %s: %v\n", service, err)
+			failed = append(failed, service)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("isolating %s failed for: %s", target, strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// splitInstance 将模板实例名（如getty@tty1）拆分为模板名（getty@）和实例名（tty1）。
+// 名称不是模板实例时ok为false。
+func splitInstance(service string) (template, instance string, ok bool) {
+	prefix, instance, found := strings.Cut(service, "@")
+	if !found || instance == "" {
+		return "", "", false
+	}
+	return prefix + "@", instance, true
+}
+
+// unescapeInstance 按systemd的规则还原转义的实例名：\xNN解码为对应字节，"-"还原为"/"。
+func unescapeInstance(instance string) string {
+	var b strings.Builder
+	for i := 0; i < len(instance); i++ {
+		switch {
+		case instance[i] == '-':
+			b.WriteByte('/')
+		case instance[i] == '\\' && i+3 < len(instance) && instance[i+1] == 'x':
+			if n, err := strconv.ParseUint(instance[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+			b.WriteByte(instance[i])
+		default:
+			b.WriteByte(instance[i])
+		}
+	}
+	return b.String()
+}
+
+// expandSpecifiers 将所有选项值中的systemd说明符替换为对应的值：
+// %n（完整单元名）、%N（不含后缀的单元名）、%p/%P（模板前缀）、%i/%I（实例名）、
+// %H（主机名）、%h（root的主目录）、%t（运行时目录）以及%%（字面的%）。
+func expandSpecifiers(opts []*unit.UnitOption, service string) {
+	prefix, instance := service, ""
+	if template, inst, ok := splitInstance(service); ok {
+		prefix, instance = strings.TrimSuffix(template, "@"), inst
+	}
+	hostname, _ := os.Hostname()
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%n", service+".service",
+		"%N", service,
+		"%p", prefix,
+		"%P", unescapeInstance(prefix),
+		"%i", instance,
+		"%I", unescapeInstance(instance),
+		"%H", hostname,
+		"%h", "/root",
+		"%t", runtimePath,
+	)
+	for _, opt := range opts {
+		opt.Value = replacer.Replace(opt.Value)
+	}
+}
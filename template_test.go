@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+func TestUnescapeInstance(t *testing.T) {
+	tests := []struct {
+		instance string
+		want     string
+	}{
+		{"tty1", "tty1"},
+		{"", ""},
+		{"dev-sda1", "dev/sda1"},
+		{"-", "/"},
+		{`foo\x2dbar`, "foo-bar"},
+		{`a\x20b`, "a b"},
+		{`\x2fetc`, "/etc"},
+		{`\xc3\xa9`, "é"},
+		{`bad\xzz`, `bad\xzz`},
+		{`short\x2`, `short\x2`},
+		{`trailing\`, `trailing\`},
+	}
+	for _, tt := range tests {
+		if got := unescapeInstance(tt.instance); got != tt.want {
+			t.Errorf("unescapeInstance(%q) = %q, want %q", tt.instance, got, tt.want)
+		}
+	}
+}
+
+func TestExpandSpecifiers(t *testing.T) {
+	opts := []*unit.UnitOption{
+		{Section: "Service", Name: "ExecStart", Value: "/usr/bin/mount-helper %i %I %n %N %p 100%%"},
+	}
+	expandSpecifiers(opts, `mount@dev-disk\x2dlabel`)
+	want := `/usr/bin/mount-helper dev-disk\x2dlabel dev/disk-label mount@dev-disk\x2dlabel.service mount@dev-disk\x2dlabel mount 100%`
+	if opts[0].Value != want {
+		t.Errorf("expanded ExecStart = %q, want %q", opts[0].Value, want)
+	}
+}
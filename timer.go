@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// bootTime 是守护进程的启动时间，OnBootSec=相对于它计算
+var bootTime = time.Now()
+
+// timerUnit 是一个已激活的.timer单元。
+type timerUnit struct {
+	// service 是定时触发的服务
+	service string
+	// onBoot 是OnBootSec=，为0表示未设置
+	onBoot time.Duration
+	// onUnitActive 是OnUnitActiveSec=，为0表示只触发一次
+	onUnitActive time.Duration
+	// stop 关闭时调度goroutine退出
+	stop chan struct{}
+}
+
+// mapTimer 跟踪已激活的timer单元，受lock保护
+var mapTimer = map[string]*timerUnit{}
+
+// StartTimer 激活timer单元，按OnBootSec=和OnUnitActiveSec=定时启动对应的服务。
+// 目前只支持这两种间隔定时器，OnCalendar=会被忽略。
+func StartTimer(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if mapTimer[name] != nil {
+		return nil
+	}
+	path := findUnitFile(name + ".timer")
+	if path == "" {
+		return errors.New("no timer found")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	opts, err := parseSystemdService(string(content))
+	if err != nil {
+		return err
+	}
+
+	timer := &timerUnit{service: name, stop: make(chan struct{})}
+	if val, _ := getOptions(opts, "Timer", "Unit"); val != "" {
+		timer.service = strings.TrimSuffix(val, ".service")
+	}
+	for _, option := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"OnBootSec", &timer.onBoot},
+		{"OnUnitActiveSec", &timer.onUnitActive},
+	} {
+		val, _ := getOptions(opts, "Timer", option.name)
+		if val == "" {
+			continue
+		}
+		if *option.dst, err = parseTimeSpan(val); err != nil {
+			return fmt.Errorf("invalid %s= in %s.timer: %w", option.name, name, err)
+		}
+	}
+	if val, _ := getOptions(opts, "Timer", "OnCalendar"); val != "" {
+		log.Printf("OnCalendar= is not supported, ignoring it in %s.timer\n", name)
+	}
+	if timer.onBoot == 0 && timer.onUnitActive == 0 {
+		return fmt.Errorf("%s.timer has no OnBootSec= or OnUnitActiveSec=", name)
+	}
+
+	mapTimer[name] = timer
+	go timer.run(name)
+	log.Printf("Started %s.timer for %s.service\n", name, timer.service)
+	return nil
+}
+
+// StopTimer 停止timer单元的调度，已启动的服务不受影响。
+func StopTimer(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	timer := mapTimer[name]
+	if timer == nil {
+		return errors.New("timer is not active")
+	}
+	close(timer.stop)
+	delete(mapTimer, name)
+	return nil
+}
+
+// run 是timer的调度循环。第一次在bootTime+OnBootSec触发（未设置OnBootSec时在激活后
+// OnUnitActiveSec触发），之后每次在上次触发后OnUnitActiveSec再次触发。
+func (t *timerUnit) run(name string) {
+	next := time.Now().Add(t.onUnitActive)
+	if t.onBoot > 0 {
+		next = bootTime.Add(t.onBoot)
+	}
+	for {
+		wait := time.NewTimer(time.Until(next))
+		select {
+		case <-t.stop:
+			wait.Stop()
+			return
+		case <-wait.C:
+		}
+
+		t.trigger(name)
+		if t.onUnitActive == 0 {
+			return
+		}
+		next = time.Now().Add(t.onUnitActive)
+	}
+}
+
+// trigger 启动定时器对应的服务，服务仍在运行时跳过本次触发。
+func (t *timerUnit) trigger(name string) {
+	defer lockService(t.service)()
+
+	if mapTimer[name] != t {
+		return
+	}
+	if isCommandRunning(t.service, mapCommand[t.service]) {
+		log.Printf("%s.timer elapsed, but %s.service is still running\n", name, t.service)
+		return
+	}
+	log.Printf("%s.timer elapsed, starting %s.service\n", name, t.service)
+	if err := start(t.service); err != nil {
+		log.Printf("Failed to start %s.service from timer: %v\n", t.service, err)
+	}
+}
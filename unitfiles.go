@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// unitFileTypes 是list-unit-files列出的单元类型
+var unitFileTypes = []string{".service", ".socket", ".timer"}
+
+// unitFileState 返回单元文件的安装状态：masked、alias（Alias=创建的链接）、
+// static（没有[Install]节）、enabled或disabled。file是完整的单元文件名，path是找到它的路径。
+func unitFileState(file, path string) string {
+	name := strings.TrimSuffix(file, ".service")
+	if target, err := os.Readlink(path); err == nil {
+		if target == os.DevNull {
+			return "masked"
+		}
+		if filepath.Base(target) != file {
+			return "alias"
+		}
+	}
+	var enabled bool
+	if isOtherUnit(name) {
+		_, err := os.Lstat(filepath.Join(enablePath, name))
+		enabled = err == nil
+	} else {
+		opts, _ := loadUnit(name)
+		for _, target := range append([]string{defaultTarget}, wantedBy(opts)...) {
+			if _, err := os.Lstat(filepath.Join(wantsDir(target), file)); err == nil {
+				enabled = true
+				break
+			}
+		}
+	}
+	if enabled {
+		return "enabled"
+	}
+	opts, _ := loadUnitFile(path)
+	if !hasInstallSection(opts) {
+		return "static"
+	}
+	return "disabled"
+}
+
+// ListUnitFiles 遍历所有单元目录，列出每个service、socket和timer单元文件的安装状态。
+// 同名单元以unitDirs中优先级最高的目录为准，与服务是否已加载或运行无关。
+func ListUnitFiles() string {
+	lock.Lock()
+	defer lock.Unlock()
+
+	paths := map[string]string{}
+	for _, dir := range unitDirs() {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			file := entry.Name()
+			if entry.IsDir() || paths[file] != "" {
+				continue
+			}
+			for _, suffix := range unitFileTypes {
+				if strings.HasSuffix(file, suffix) {
+					paths[file] = filepath.Join(dir, file)
+				}
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return "No unit files found."
+	}
+	files := make([]string, 0, len(paths))
+	for file := range paths {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%-40s %s\n", "UNIT FILE", "STATE")
+	for _, file := range files {
+		_, _ = fmt.Fprintf(&b, "%-40s %s\n", file, unitFileState(file, paths[file]))
+	}
+	_, _ = fmt.Fprintf(&b, "\n%d unit files listed.", len(files))
+	return b.String()
+}
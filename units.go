@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ListUnits 列出守护进程跟踪的服务及其LoadState、ActiveState和SubState。
+// states为空时与systemd一致不列出inactive的服务；否则只列出ActiveState、SubState或LoadState
+// 是其中之一的服务（如failed），被屏蔽的服务只有明确指定masked时才会列出。
+func ListUnits(states []string) string {
+	lock.Lock()
+	defer lock.Unlock()
+
+	wanted := map[string]bool{}
+	for _, state := range states {
+		wanted[state] = true
+	}
+	seen := map[string]bool{}
+	for service := range mapState {
+		seen[service] = true
+	}
+	for service := range mapCommand {
+		seen[service] = true
+	}
+
+	type row struct{ service, load, active, sub, description string }
+	var rows []row
+	for service := range seen {
+		opts, _ := loadUnit(service)
+		load := unitLoadState(service)
+		active, sub := unitActiveState(service, opts)
+		match := (len(wanted) == 0 && active != "inactive") || wanted[active] || wanted[sub] || wanted[load]
+		if !match || (load == "masked" && !wanted["masked"]) {
+			continue
+		}
+		description, _ := getOptions(opts, "Unit", "Description")
+		rows = append(rows, row{service + ".service", load, active, sub, description})
+	}
+	if len(rows) == 0 {
+		return "0 loaded units listed."
+	}
+	sort.Slice(rows, func(i, k int) bool { return rows[i].service < rows[k].service })
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%-30s %-9s %-12s %-9s %s\n", "UNIT", "LOAD", "ACTIVE", "SUB", "DESCRIPTION")
+	for _, r := range rows {
+		_, _ = fmt.Fprintf(&b, "%-30s %-9s %-12s %-9s %s\n", r.service, r.load, r.active, r.sub, r.description)
+	}
+	_, _ = fmt.Fprintf(&b, "\n%d loaded units listed.", len(rows))
+	return b.String()
+}
+
+// parseListUnitsArgs 解析list-units的过滤参数：--failed等同于--state=failed，
+// --state可以出现多次，每个值也可以是以","分隔的多个状态。
+func parseListUnitsArgs(args []string) ([]string, error) {
+	var states []string
+	for i := 0; i < len(args); i++ {
+		var val string
+		switch {
+		case args[i] == "--failed":
+			val = "failed"
+		case args[i] == "--state":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s requires a value", args[i])
+			}
+			val = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--state="):
+			val = strings.TrimPrefix(args[i], "--state=")
+		default:
+			return nil, fmt.Errorf("unknown option: %s", args[i])
+		}
+		for _, state := range strings.Split(val, ",") {
+			if state != "" {
+				states = append(states, state)
+			}
+		}
+	}
+	return states, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks 是/proc中CPU时间的单位（USER_HZ），Linux上几乎总是100
+const clockTicks = 100
+
+// resourceUsage 是服务所有进程的资源用量。
+type resourceUsage struct {
+	// cpu 是用户态和内核态CPU时间之和
+	cpu time.Duration
+	// rss 是常驻内存（VmRSS），单位为字节
+	rss uint64
+	// tasks 是统计到的进程数
+	tasks int
+}
+
+// serviceUsage 统计服务的资源用量。主进程是进程组组长时（服务以Setsid启动）累加整个进程组，
+// 否则只统计主进程。统计过程中退出的进程会被忽略。
+func serviceUsage(pid int) resourceUsage {
+	var usage resourceUsage
+	fields := procStat(pid)
+	if len(fields) < 13 || fields[2] != strconv.Itoa(pid) {
+		usage.add(pid, fields)
+		return usage
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		usage.add(pid, fields)
+		return usage
+	}
+	for _, entry := range entries {
+		p, err2 := strconv.Atoi(entry.Name())
+		if err2 != nil {
+			continue
+		}
+		if f := procStat(p); len(f) >= 13 && f[2] == fields[2] {
+			usage.add(p, f)
+		}
+	}
+	return usage
+}
+
+// add 累加一个进程的CPU时间和常驻内存，fields是procStat的结果，为空表示进程已退出。
+func (u *resourceUsage) add(pid int, fields []string) {
+	if len(fields) < 13 {
+		return
+	}
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	u.cpu += time.Duration(utime+stime) * time.Second / clockTicks
+	u.rss += processRSS(pid)
+	u.tasks++
+}
+
+// procStat 返回/proc/<pid>/stat中comm之后的字段（从state开始），进程不存在时返回nil。
+func procStat(pid int) []string {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return nil
+	}
+	// comm可能包含空格和括号，从最后一个")"之后解析
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return nil
+	}
+	return strings.Fields(string(data[i+1:]))
+}
+
+// processRSS 读取/proc/<pid>/status中的VmRSS，以字节为单位，无法读取时返回0。
+func processRSS(pid int) uint64 {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if val, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kb, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(val), " kB"), 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// formatBytes 以systemd的风格格式化字节数，如512B、1.5M。
+func formatBytes(n uint64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n)
+	i := -1
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + string(units[i])
+}
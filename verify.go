@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// knownDirectives 列出各节中被识别的指令：本实现支持的指令，以及只影响systemd自身、
+// 可以安全忽略的常见指令。以X-开头的指令按systemd约定总是允许的。
+var knownDirectives = map[string][]string{
+	"Unit": {
+		"Description", "Documentation", "Requires", "Wants", "After", "Before", "Conflicts",
+		"StartLimitIntervalSec", "StartLimitBurst", "DefaultDependencies", "PartOf", "BindsTo",
+		"ConditionPathExists", "ConditionPathIsDirectory", "ConditionFileNotEmpty", "ConditionHost",
+		"AssertPathExists", "AssertPathIsDirectory", "AssertFileNotEmpty", "AssertHost", "CollectMode",
+	},
+	"Service": {
+		"Type", "ExecStart", "ExecCondition", "ExecStartPre", "ExecStartPost", "ExecReload", "ExecStop",
+		"Restart", "RemainAfterExit", "PIDFile", "User", "Group", "WorkingDirectory",
+		"Environment", "EnvironmentFile", "StandardOutput", "StandardError", "SyslogIdentifier",
+		"TimeoutSec", "TimeoutStartSec", "TimeoutStopSec", "TimeoutAbortSec", "KillMode", "KillSignal",
+		"RuntimeDirectory", "RuntimeDirectoryMode", "RuntimeDirectoryPreserve",
+		"IPAddressAllow", "IPAddressDeny", "Nice", "OOMScoreAdjust", "MemoryMax", "MemoryLimit", "CPUQuota", "CPUWeight",
+		"LimitNOFILE", "LimitNPROC", "LimitCORE", "LimitFSIZE", "LimitSTACK", "LimitAS",
+		"WatchdogSec", "RestartSec", "RestartMaxDelaySec", "SuccessExitStatus", "RestartSteps", "NotifyAccess", "PrivateTmp", "ProtectSystem", "ProtectHome", "NoNewPrivileges",
+	},
+	"Install": {"WantedBy", "RequiredBy", "Alias", "Also"},
+}
+
+// Verify 校验单个服务的单元文件，返回每行一个问题的报告以及是否没有错误。
+// 错误包括：缺少ExecStart、ExecStart可执行文件不存在、WorkingDirectory不存在、Requires=依赖找不到等；
+// 警告包括：无法识别的节或指令、Wants=/After=/Before=引用的单元找不到。警告不影响结果。
+func Verify(service string) (string, bool) {
+	problems := checkUnit(service)
+	var warnings []string
+	if opts, err := loadUnit(service); err == nil {
+		problems = append(problems, checkWorkingDirectoryOption(opts)...)
+		warnings = unitWarnings(opts)
+	}
+
+	var lines []string
+	for _, problem := range problems {
+		lines = append(lines, fmt.Sprintf("%s.service: error: %s", service, problem))
+	}
+	for _, warning := range warnings {
+		lines = append(lines, fmt.Sprintf("%s.service: warning: %s", service, warning))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("%s.service: OK", service))
+	}
+	return strings.Join(lines, "\n"), len(problems) == 0
+}
+
+// checkWorkingDirectoryOption 检查WorkingDirectory=指向的目录是否存在，以"-"开头的可选目录除外。
+func checkWorkingDirectoryOption(opts []*unit.UnitOption) []string {
+	dir, _ := getOptions(opts, "Service", "WorkingDirectory")
+	if dir == "" || strings.HasPrefix(dir, "-") {
+		return nil
+	}
+	if err := checkWorkingDirectory(dir); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// unitWarnings 返回单元中无法识别的节和指令，以及引用了不存在单元的Wants=/After=/Before=。
+func unitWarnings(opts []*unit.UnitOption) []string {
+	known := map[string]map[string]bool{}
+	for section, names := range knownDirectives {
+		known[section] = map[string]bool{}
+		for _, name := range names {
+			known[section][name] = true
+		}
+	}
+
+	var warnings []string
+	reported := map[string]bool{}
+	for _, opt := range opts {
+		if strings.HasPrefix(opt.Name, "X-") || strings.HasPrefix(opt.Section, "X-") {
+			continue
+		}
+		// 无法识别的节只报告一次，其中的指令不再逐个报告
+		switch {
+		case known[opt.Section] == nil:
+			if !reported[opt.Section] {
+				warnings = append(warnings, fmt.Sprintf("unknown section [%s]", opt.Section))
+				reported[opt.Section] = true
+			}
+		case !known[opt.Section][opt.Name]:
+			if key := opt.Section + "." + opt.Name; !reported[key] {
+				warnings = append(warnings, fmt.Sprintf("unknown directive %s= in [%s]", opt.Name, opt.Section))
+				reported[key] = true
+			}
+		}
+	}
+
+	for _, directive := range []string{"Wants", "After", "Before"} {
+		for _, dep := range unitNames(getAllOptions(opts, "Unit", directive)) {
+			if strings.Contains(dep, ".") {
+				// .target等非服务单元不检查
+				continue
+			}
+			if find(dep) == "" {
+				warnings = append(warnings, fmt.Sprintf("%s= references unit %s.service, which does not exist", directive, dep))
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/unit"
+)
+
+// watchdogTimeout 读取WatchdogSec=，未设置、为0或无效时返回0表示不启用看门狗。
+func watchdogTimeout(opts []*unit.UnitOption) time.Duration {
+	val, _ := getOptions(opts, "Service", "WatchdogSec")
+	if val == "" {
+		return 0
+	}
+	d, err := parseTimeSpan(val)
+	if err != nil || d == timeSpanInfinity {
+		if err != nil {
+			log.Printf("Invalid WatchdogSec: %v\n", err)
+		}
+		return 0
+	}
+	return d
+}
+
+// watchdogEnv 返回告知服务看门狗间隔的WATCHDOG_USEC环境变量。
+func watchdogEnv(timeout time.Duration) string {
+	return "WATCHDOG_USEC=" + strconv.FormatInt(timeout.Microseconds(), 10)
+}
+
+// abortTimeout 返回看门狗发送SIGABRT之后等待服务退出的时间，即TimeoutAbortSec=，未设置时与TimeoutStopSec=相同。
+func abortTimeout(opts []*unit.UnitOption) time.Duration {
+	if val, _ := getOptions(opts, "Service", "TimeoutAbortSec"); val != "" {
+		return timeoutOption(opts, "TimeoutAbortSec", defaultTimeoutStopSec)
+	}
+	return timeoutOption(opts, "TimeoutStopSec", defaultTimeoutStopSec)
+}
+
+// watchdog 监视服务的WATCHDOG=1心跳：超过timeout没有收到心跳，或服务发送WATCHDOG=trigger时，
+// 认为服务已挂起，向主进程发送SIGABRT使其退出，之后按Restart=决定是否重启。服务被freeze期间不计超时。
+// 服务在abort时间内仍未退出时向整个进程组发送SIGKILL。服务退出后返回。
+func (n *notifySocket) watchdog(service string, pid int, timeout, abort time.Duration, exited <-chan struct{}) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case ping := <-n.pings:
+			if ping != "trigger" {
+				timer.Reset(timeout)
+				continue
+			}
+			log.Printf("Service %s triggered its watchdog\n", service)
+		case <-timer.C:
+			// 被冻结的服务无法发送心跳，不视为挂起
+			lock.Lock()
+			frozen := isFrozen(service)
+			lock.Unlock()
+			if frozen {
+				timer.Reset(timeout)
+				continue
+			}
+			log.Printf("Watchdog timeout for service %s (no WATCHDOG=1 within %v), aborting it\n", service, timeout)
+		}
+		n.watchdogFired.Store(true)
+		if err := signalProcess(pid, syscall.SIGABRT, false); err != nil {
+			log.Printf("Failed to send SIGABRT to %s: %v\n", service, err)
+		}
+		break
+	}
+
+	// 主进程在exited关闭之前不会被回收，其PID不会被复用
+	var expired <-chan time.Time
+	if abort != timeSpanInfinity {
+		expired = time.After(abort)
+	}
+	select {
+	case <-exited:
+	case <-expired:
+		log.Printf("Service %s did not exit within %v after SIGABRT, killing it\n", service, abort)
+		if err := signalProcess(pid, syscall.SIGKILL, true); err != nil {
+			log.Printf("Failed to send SIGKILL to %s: %v\n", service, err)
+		}
+	}
+}